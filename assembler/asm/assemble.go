@@ -0,0 +1,68 @@
+package asm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// Options configures AssembleWithOptions.
+type Options struct {
+	// Extended enables the extended instruction set. See Parser.SetExtended.
+	Extended bool
+}
+
+// Assemble translates Hack assembly read from r into machine code.
+// The returned SymbolTable holds the predefined symbols, labels and
+// variables resolved during assembly.
+func Assemble(r io.Reader) ([]uint16, *SymbolTable, error) {
+	return AssembleWithOptions(r, Options{})
+}
+
+// AssembleWithOptions is like Assemble but configured by opts.
+func AssembleWithOptions(r io.Reader, opts Options) ([]uint16, *SymbolTable, error) {
+	// The input is read at once because assembly takes two passes.
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read asm: %w", err)
+	}
+
+	// 1pass
+	symbolTable := NewSymbolTable()
+	parser := NewParser(bytes.NewReader(src))
+	parser.SetExtended(opts.Extended)
+	if err := symbolTable.LoadLabelAddress(parser); err != nil {
+		return nil, nil, err
+	}
+
+	// 2pass
+	parser = NewParser(bytes.NewReader(src))
+	parser.SetExtended(opts.Extended)
+	var words []uint16
+	for parser.Parse() {
+		var bin uint16
+		var err error
+		switch cmd := parser.CurrentCommand().(type) {
+		case *ACommand:
+			if cmd.SymbolIsDigit {
+				bin, err = ConvertACommand(cmd)
+			} else if addr, ok := symbolTable.GetAddress(cmd.Symbol); ok {
+				bin = addr
+			} else {
+				bin = symbolTable.AddAutoEntry(cmd.Symbol)
+			}
+		case *CCommand:
+			bin, err = ConvertCCommand(cmd)
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("line %d: failed to convert command to binary: %w", parser.Line(), err)
+		}
+		words = append(words, bin)
+	}
+	if err := parser.Err(); err != nil {
+		return nil, nil, err
+	}
+	return words, symbolTable, nil
+}
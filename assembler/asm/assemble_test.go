@@ -0,0 +1,106 @@
+package asm
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestAssemble(t *testing.T) {
+	input := `// Computes R2 = max(R0, R1)
+   @R0
+   D=M
+   @R1
+   D=D-M
+   @OUTPUT_FIRST
+   D;JGT
+   @R1
+   D=M
+   @OUTPUT_D
+   0;JMP
+(OUTPUT_FIRST)
+   @R0
+   D=M
+(OUTPUT_D)
+   @R2
+   M=D
+(INFINITE_LOOP)
+   @INFINITE_LOOP
+   0;JMP
+   @i
+   M=1
+`
+
+	wants := []uint16{
+		0b0000000000000000,
+		0b1111110000010000,
+		0b0000000000000001,
+		0b1111010011010000,
+		0b0000000000001010,
+		0b1110001100000001,
+		0b0000000000000001,
+		0b1111110000010000,
+		0b0000000000001100,
+		0b1110101010000111,
+		0b0000000000000000,
+		0b1111110000010000,
+		0b0000000000000010,
+		0b1110001100001000,
+		0b0000000000001110,
+		0b1110101010000111,
+		0b0000000000010000,
+		0b1110111111001000,
+	}
+
+	got, symbolTable, err := Assemble(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(wants) {
+		t.Fatalf("want %d words, but got %d", len(wants), len(got))
+	}
+	for i, want := range wants {
+		if got[i] != want {
+			t.Errorf("want %016b, but got %016b at ROM[%d]", want, got[i], i)
+		}
+	}
+
+	for symbol, want := range map[string]Address{"OUTPUT_FIRST": 10, "INFINITE_LOOP": 14, "i": 16} {
+		if got, ok := symbolTable.GetAddress(symbol); !ok || got != want {
+			t.Errorf("want %s to be %d, but got %d", symbol, want, got)
+		}
+	}
+}
+
+func TestAssembleReportsParseError(t *testing.T) {
+	input := "@R0\nD=M\n\nD=X\n"
+
+	_, _, err := Assemble(strings.NewReader(input))
+	var perr *ParseError
+	if !errors.As(err, &perr) {
+		t.Fatalf("want ParseError, but got %v", err)
+	}
+	if perr.Line != 4 {
+		t.Errorf("want error at line 4, but got line %d", perr.Line)
+	}
+}
+
+func TestAssembleExtended(t *testing.T) {
+	input := "D=A+D\nAM=M|D;JMP\nD=D<<\nM=M>>\n"
+	wants := []uint16{
+		0b1110000010010000,
+		0b1111010101101111,
+		0b1010110000010000,
+		0b1011000000001000,
+	}
+
+	got, _, err := AssembleWithOptions(strings.NewReader(input), Options{Extended: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, want := range wants {
+		if got[i] != want {
+			t.Errorf("want %016b, but got %016b at ROM[%d]", want, got[i], i)
+		}
+	}
+}
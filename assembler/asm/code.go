@@ -1,4 +1,4 @@
-package main
+package asm
 
 import (
 	"fmt"
@@ -14,6 +14,11 @@ const (
 	A
 
 	C_INSTRUCTION_MARKER = 0b111 << 13
+	// SHIFT_INSTRUCTION_MARKER marks the shift instructions of the extended
+	// instruction set: 101a_LDxx_xxdd_djjj. The a-bit selects M instead of A
+	// as usual, L selects a left shift (otherwise arithmetic right shift) and
+	// D selects the D-register as the operand.
+	SHIFT_INSTRUCTION_MARKER = 0b101 << 13
 )
 
 var (
@@ -67,6 +72,14 @@ var (
 		"D&M": 0b1_000_000 << 6,
 		"D|M": 0b1_010_101 << 6,
 	}
+	shiftCompMnemonicToBinary = map[string]uint16{
+		"A<<": 0b0_100_000 << 6,
+		"D<<": 0b0_110_000 << 6,
+		"M<<": 0b1_100_000 << 6,
+		"A>>": 0b0_000_000 << 6,
+		"D>>": 0b0_010_000 << 6,
+		"M>>": 0b1_000_000 << 6,
+	}
 )
 
 func ConvertACommand(c *ACommand) (uint16, error) {
@@ -79,8 +92,11 @@ func ConvertACommand(c *ACommand) (uint16, error) {
 }
 
 func ConvertCCommand(c *CCommand) (uint16, error) {
-	comp := compMnemonicToBinary[c.Comp]
 	dest := destMnemonicToBinary[c.Dest]
 	jump := jumpMnemonicToBinary[c.Jump]
+	if comp, ok := shiftCompMnemonicToBinary[c.Comp]; ok {
+		return comp | dest | jump | SHIFT_INSTRUCTION_MARKER, nil
+	}
+	comp := compMnemonicToBinary[c.Comp]
 	return comp | dest | jump | C_INSTRUCTION_MARKER, nil
 }
@@ -0,0 +1,169 @@
+package asm
+
+import (
+	"bufio"
+	"bytes"
+	"strings"
+)
+
+// instructionIndent is the indentation of A- and C-commands.
+const instructionIndent = "    "
+
+type formatLine struct {
+	indent  string
+	code    string // canonical command
+	comment string // starts with "//"
+}
+
+// Format normalizes the layout of Hack assembly. Labels are placed at
+// column 0, instructions are indented, trailing comments of consecutive commands
+// are aligned and C-commands are spelled canonically (`D;JGT`, `AMD=D+A`).
+// Formatting is idempotent.
+func Format(src []byte) ([]byte, error) {
+	return FormatWithOptions(src, Options{})
+}
+
+// FormatWithOptions is like Format but configured by opts. With the
+// extended instruction set, commutative spellings are rewritten to the
+// standard ones.
+func FormatWithOptions(src []byte, opts Options) ([]byte, error) {
+	var lines []formatLine
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line, err := parseFormatLine(scanner.Text(), opts.Extended)
+		if err != nil {
+			err.Line = lineNum
+			return nil, err
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	lines = squeezeBlankLines(lines)
+	indentComments(lines)
+
+	var buf bytes.Buffer
+	for i := 0; i < len(lines); {
+		// Align trailing comments in a run of consecutive commands.
+		j := i
+		width := 0
+		for ; j < len(lines) && lines[j].code != ""; j++ {
+			if lines[j].comment != "" {
+				width = max(width, len(lines[j].indent+lines[j].code))
+			}
+		}
+		if j == i {
+			j++
+		}
+		for _, line := range lines[i:j] {
+			text := line.indent + line.code
+			if line.code != "" && line.comment != "" {
+				text += strings.Repeat(" ", width-len(text)+1)
+			}
+			text += line.comment
+			buf.WriteString(strings.TrimRight(text, " \t"))
+			buf.WriteByte('\n')
+		}
+		i = j
+	}
+	return buf.Bytes(), nil
+}
+
+func parseFormatLine(text string, extended bool) (formatLine, *ParseError) {
+	var line formatLine
+	if i := strings.Index(text, "//"); i >= 0 {
+		line.comment = strings.TrimRight(text[i:], " \t\r")
+		text = text[:i]
+	}
+	word := string(removeWhiteSpaces([]byte(strings.TrimRight(text, "\r"))))
+	if word == "" {
+		return line, nil
+	}
+
+	if !strings.HasPrefix(word, "@") && !strings.HasPrefix(word, "(") {
+		word = canonicalizeDest(word)
+	}
+	cmd, err := parse(word, extended)
+	if err != nil {
+		return line, err
+	}
+	switch cmd := cmd.(type) {
+	case *LCommand:
+		line.code = cmd.String()
+	case *ACommand:
+		line.indent = instructionIndent
+		line.code = cmd.String()
+	case *CCommand:
+		line.indent = instructionIndent
+		line.code = formatCCommand(cmd)
+	}
+	return line, nil
+}
+
+// canonicalizeDest rewrites dest of a C-command to uppercase in the order
+// of A, M and D. It leaves invalid dest as is for the parser to report.
+func canonicalizeDest(word string) string {
+	i := strings.Index(word, "=")
+	if i < 0 {
+		return word
+	}
+	dest := strings.ToUpper(word[:i])
+	var canonical string
+	for _, r := range "AMD" {
+		if strings.Count(dest, string(r)) == 1 {
+			canonical += string(r)
+		}
+	}
+	if len(canonical) != len(dest) {
+		return word
+	}
+	return canonical + word[i:]
+}
+
+func formatCCommand(cmd *CCommand) string {
+	s := cmd.Comp
+	if cmd.Dest != "" {
+		s = cmd.Dest + "=" + s
+	}
+	if cmd.Jump != "" {
+		s += ";" + cmd.Jump
+	}
+	return s
+}
+
+// squeezeBlankLines removes leading and trailing blank lines
+// and merges consecutive blank lines into one.
+func squeezeBlankLines(lines []formatLine) []formatLine {
+	var squeezed []formatLine
+	blank := false
+	for _, line := range lines {
+		if line.code == "" && line.comment == "" {
+			blank = true
+			continue
+		}
+		if blank && len(squeezed) > 0 {
+			squeezed = append(squeezed, formatLine{})
+		}
+		blank = false
+		squeezed = append(squeezed, line)
+	}
+	return squeezed
+}
+
+// indentComments indents comment only lines as the command directly following
+// them. Comments followed by a blank line or the end of file are not indented.
+func indentComments(lines []formatLine) {
+	indent := ""
+	for i := len(lines) - 1; i >= 0; i-- {
+		switch {
+		case lines[i].code != "":
+			indent = lines[i].indent
+		case lines[i].comment != "":
+			lines[i].indent = indent
+		default:
+			indent = ""
+		}
+	}
+}
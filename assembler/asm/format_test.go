@@ -0,0 +1,82 @@
+package asm
+
+import (
+	"testing"
+)
+
+func TestFormat(t *testing.T) {
+	input := `// Computes R2 = max(R0, R1)
+
+
+   // D = R0 - R1
+@R0
+  D = M   // load R0
+   @R1
+ D=D-M // subtract R1
+	DM=D
+   amd=A+D
+  @ITSR0
+   D ; JGT
+
+
+  ( ITSR0 )
+0; JMP
+   @END// goto end
+`
+
+	want := `// Computes R2 = max(R0, R1)
+
+    // D = R0 - R1
+    @R0
+    D=M   // load R0
+    @R1
+    D=D-M // subtract R1
+    MD=D
+    AMD=D+A
+    @ITSR0
+    D;JGT
+
+(ITSR0)
+    0;JMP
+    @END // goto end
+`
+
+	extended := Options{Extended: true} // for the commutative spelling
+	got, err := FormatWithOptions([]byte(input), extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("want\n%s\nbut got\n%s", want, got)
+	}
+
+	again, err := FormatWithOptions(got, extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(again) != string(got) {
+		t.Errorf("Format is not idempotent:\n%s", again)
+	}
+}
+
+func TestFormatReportsParseError(t *testing.T) {
+	_, err := Format([]byte("@R0\nD=X\n"))
+	perr, ok := err.(*ParseError)
+	if !ok {
+		t.Fatalf("want ParseError, but got %v", err)
+	}
+	if perr.Line != 2 {
+		t.Errorf("want error at line 2, but got line %d", perr.Line)
+	}
+}
+
+func TestFormatExtendedInstructions(t *testing.T) {
+	for _, src := range []string{"D=D<<\n", "AMD=A+D\n"} {
+		if _, err := Format([]byte(src)); err == nil {
+			t.Errorf("want %q rejected without the extended instruction set", src)
+		}
+		if _, err := FormatWithOptions([]byte(src), Options{Extended: true}); err != nil {
+			t.Errorf("want %q accepted with the extended instruction set, but got %v", src, err)
+		}
+	}
+}
@@ -0,0 +1,121 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Warning is a suspicious construct found by Lint.
+type Warning struct {
+	Line    int
+	Message string
+}
+
+func (w Warning) String() string {
+	return fmt.Sprintf("line %d: %s", w.Line, w.Message)
+}
+
+type lintCommand struct {
+	Command
+	line int
+}
+
+// Lint checks the command stream for patterns that are legal Hack assembly
+// but usually bugs. Warnings are sorted by line number.
+func Lint(p *Parser) ([]Warning, error) {
+	var commands []lintCommand
+	for p.Parse() {
+		commands = append(commands, lintCommand{p.CurrentCommand(), p.Line()})
+	}
+	if err := p.Err(); err != nil {
+		return nil, err
+	}
+
+	var warnings []Warning
+	warn := func(line int, format string, args ...any) {
+		warnings = append(warnings, Warning{line, fmt.Sprintf(format, args...)})
+	}
+
+	labels := make(map[string]int) // label -> line
+	for _, c := range commands {
+		if cmd, ok := c.Command.(*LCommand); ok {
+			if _, ok := labels[cmd.Symbol]; !ok {
+				labels[cmd.Symbol] = c.line
+			}
+		}
+	}
+
+	predefined := NewSymbolTable()
+	referenced := make(map[string]bool)
+	reportedVariables := make(map[string]bool)
+	for i, c := range commands {
+		switch cmd := c.Command.(type) {
+		case *ACommand:
+			if cmd.SymbolIsDigit {
+				continue
+			}
+			referenced[cmd.Symbol] = true
+			if _, ok := labels[cmd.Symbol]; ok {
+				lintLabelAsRAMAddress(cmd, commands[i+1:], warn)
+				continue
+			}
+			if _, ok := predefined.GetAddress(cmd.Symbol); ok || reportedVariables[cmd.Symbol] {
+				continue
+			}
+			for label := range labels {
+				if strings.EqualFold(label, cmd.Symbol) {
+					warn(c.line, "variable %q differs only by case from label %q", cmd.Symbol, label)
+					reportedVariables[cmd.Symbol] = true
+					break
+				}
+			}
+		case *CCommand:
+			if strings.Contains(cmd.Dest, "A") && cmd.Jump != "" {
+				warn(c.line, "%q writes A and jumps at once; the jump target is the old value of A", cmd.Dest+"="+cmd.Comp+";"+cmd.Jump)
+			}
+		}
+	}
+
+	for label, line := range labels {
+		if !referenced[label] {
+			warn(line, "label %q is never used", label)
+		}
+	}
+
+	if last, ok := lastInstruction(commands); ok {
+		if cmd, ok := last.Command.(*CCommand); !ok || cmd.Jump != "JMP" {
+			warn(last.line, "program falls off the end; finish with an infinite loop such as `(END) @END 0;JMP`")
+		}
+	}
+
+	sort.SliceStable(warnings, func(i, j int) bool {
+		return warnings[i].Line < warnings[j].Line
+	})
+	return warnings, nil
+}
+
+// lintLabelAsRAMAddress warns when a C-command accesses M right after a label
+// address is loaded into A. Labels are ROM addresses, so RAM[label] is almost
+// always a mistake.
+func lintLabelAsRAMAddress(a *ACommand, rest []lintCommand, warn func(int, string, ...any)) {
+	if len(rest) == 0 {
+		return
+	}
+	next, ok := rest[0].Command.(*CCommand)
+	if !ok {
+		return
+	}
+	if strings.Contains(next.Comp, "M") || strings.Contains(next.Dest, "M") {
+		warn(rest[0].line, "M refers to RAM[%s] but %q is a label (ROM address)", a.Symbol, a.Symbol)
+	}
+}
+
+func lastInstruction(commands []lintCommand) (lintCommand, bool) {
+	for i := len(commands) - 1; i >= 0; i-- {
+		if _, ok := commands[i].Command.(*LCommand); !ok {
+			return commands[i], true
+		}
+	}
+	return lintCommand{}, false
+}
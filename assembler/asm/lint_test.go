@@ -0,0 +1,63 @@
+package asm
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLint(t *testing.T) {
+	input := `// comment
+   @LOOP
+   D=M        // label used as RAM address
+(LOOP)
+   @loop
+   M=0        // variable differs by case
+   @LOOP
+   AM=M-1;JGT // writes A and jumps
+(UNUSED)
+   @i
+   M=D        // falls off the end
+`
+
+	wants := []Warning{
+		{3, `M refers to RAM[LOOP] but "LOOP" is a label (ROM address)`},
+		{5, `variable "loop" differs only by case from label "LOOP"`},
+		{8, `M refers to RAM[LOOP] but "LOOP" is a label (ROM address)`},
+		{8, `"AM=M-1;JGT" writes A and jumps at once; the jump target is the old value of A`},
+		{9, `label "UNUSED" is never used`},
+		{11, "program falls off the end; finish with an infinite loop such as `(END) @END 0;JMP`"},
+	}
+
+	got, err := Lint(NewParser(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != len(wants) {
+		t.Fatalf("want %d warnings, but got %d: %v", len(wants), len(got), got)
+	}
+	for i, want := range wants {
+		if got[i] != want {
+			t.Errorf("want %v, but got %v", want, got[i])
+		}
+	}
+}
+
+func TestLintCleanProgram(t *testing.T) {
+	input := `@R0
+D=M
+@END
+D;JGT
+@R1
+M=D
+(END)
+@END
+0;JMP`
+
+	got, err := Lint(NewParser(strings.NewReader(input)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("want no warnings, but got %v", got)
+	}
+}
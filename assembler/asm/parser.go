@@ -1,4 +1,4 @@
-package main
+package asm
 
 import (
 	"bufio"
@@ -12,21 +12,53 @@ import (
 type Parser struct {
 	scanner        *bufio.Scanner
 	currentCommand Command
-	eof            bool
+	err            error
+	extended       bool
+	line           int // line number of currentCommand
+	scannedLines   int // number of lines consumed by scanner
 }
 
 func NewParser(r io.Reader) *Parser {
-	scanner := bufio.NewScanner(r)
-	scanner.Split(scanCommand)
-	return &Parser{scanner: scanner}
+	p := &Parser{scanner: bufio.NewScanner(r)}
+	p.scanner.Split(p.scanCommand)
+	return p
 }
 
 func (p *Parser) CurrentCommand() Command {
 	return p.currentCommand
 }
 
+// SetExtended enables the extended instruction set, which accepts
+// commutative comp spellings such as `A+D` and the shift instructions.
+func (p *Parser) SetExtended(extended bool) {
+	p.extended = extended
+}
+
+// Line returns the 1-based line number of the current command.
+func (p *Parser) Line() int {
+	return p.line
+}
+
+// scanCommand wraps scanCommand to keep track of line numbers.
+func (p *Parser) scanCommand(data []byte, atEOF bool) (advance int, token []byte, err error) {
+	advance, token, err = scanCommand(data, atEOF)
+	if token == nil {
+		return
+	}
+	newlines := bytes.Count(data[:advance], newline)
+	if advance > 0 && data[advance-1] == '\n' {
+		p.line = p.scannedLines + newlines
+	} else {
+		// The last line has no trailing newline.
+		p.line = p.scannedLines + newlines + 1
+	}
+	p.scannedLines += newlines
+	return
+}
+
 var (
 	commentPrefix = []byte("//")
+	newline       = []byte("\n")
 	whiteSpaces   = []string{" ", "\t"}
 )
 
@@ -60,34 +92,45 @@ func scanCommand(data []byte, atEOF bool) (advance int, token []byte, err error)
 
 // Parse scans a command and parse it.
 // If successfully parsed it returns true, otherwise returns false.
+// After Parse returns false, Err reports the error if any.
 func (p *Parser) Parse() bool {
+	if p.err != nil {
+		return false
+	}
+	p.currentCommand = nil
 	if !p.scanner.Scan() {
 		if err := p.scanner.Err(); err != nil {
-			Die("failed to scan asm file: %v", err)
+			p.err = fmt.Errorf("failed to scan asm: %w", err)
 		}
-		p.eof = true
-		p.currentCommand = nil
 		return false
 	}
 
 	word := p.scanner.Text()
-	cmd, err := parse(word)
+	cmd, err := parse(word, p.extended)
 	if err != nil {
-		Die(err.Error())
+		err.Line = p.line
+		p.err = err
+		return false
 	}
 
 	p.currentCommand = cmd
 	return true
 }
 
-func parse(word string) (Command, error) {
+// Err returns the first error encountered by Parse.
+// It returns nil when parsing stopped at the end of input.
+func (p *Parser) Err() error {
+	return p.err
+}
+
+func parse(word string, extended bool) (Command, *ParseError) {
 	switch {
 	case strings.HasPrefix(word, "@"):
 		return parseACommand(word)
 	case strings.HasPrefix(word, "("):
 		return parseLCommand(word)
 	default:
-		return parseCCommand(word)
+		return parseCCommand(word, extended)
 	}
 }
 
@@ -137,10 +180,24 @@ var (
 		"M", "!M", "-M", "M+1", "M-1", "D+M", "D-M", "M-D", "D&M", "D|M")
 	destMnemonics = NewSet("", "A", "D", "M", "AD", "AM", "MD", "AMD")
 	jumpMnemonics = NewSet("", "JGT", "JEQ", "JGE", "JLT", "JNE", "JLE", "JMP")
+
+	// Extended mode accepts commutative spellings of comp mnemonics.
+	commutativeCompMnemonics = map[string]string{
+		"1+D": "D+1",
+		"1+A": "A+1",
+		"1+M": "M+1",
+		"A+D": "D+A",
+		"A&D": "D&A",
+		"A|D": "D|A",
+		"M+D": "D+M",
+		"M&D": "D&M",
+		"M|D": "D|M",
+	}
+	shiftCompMnemonics = NewSet("A<<", "D<<", "M<<", "A>>", "D>>", "M>>")
 )
 
 // parseACommand parses `@symbol`
-func parseACommand(word string) (*ACommand, error) {
+func parseACommand(word string) (*ACommand, *ParseError) {
 	symbol := word[1:] // remove @
 	if !isValidSymbol(symbol) {
 		return nil, NewParseError("invalid symbol", symbol)
@@ -153,7 +210,7 @@ func parseACommand(word string) (*ACommand, error) {
 }
 
 // parseCCommand parses `dest=comp; jump`
-func parseCCommand(word string) (*CCommand, error) {
+func parseCCommand(word string, extended bool) (*CCommand, *ParseError) {
 	cmd := CCommand{}
 	if i := strings.Index(word, "="); i >= 0 {
 		dest := word[:i]
@@ -171,7 +228,12 @@ func parseCCommand(word string) (*CCommand, error) {
 		cmd.Jump = jump
 		word = word[:i]
 	}
-	if !compMnemonics.Contains(word) {
+	if extended {
+		if canonical, ok := commutativeCompMnemonics[word]; ok {
+			word = canonical
+		}
+	}
+	if !compMnemonics.Contains(word) && !(extended && shiftCompMnemonics.Contains(word)) {
 		return nil, NewParseError("unknown comp mnemonic", word)
 	}
 	cmd.Comp = word
@@ -179,7 +241,7 @@ func parseCCommand(word string) (*CCommand, error) {
 }
 
 // parseLCommand parses `(SYMBOL)`
-func parseLCommand(word string) (*LCommand, error) {
+func parseLCommand(word string) (*LCommand, *ParseError) {
 	if word[len(word)-1] != ')' {
 		return nil, NewParseError("closing paren is not found", word)
 	}
@@ -192,6 +254,7 @@ func parseLCommand(word string) (*LCommand, error) {
 }
 
 type ParseError struct {
+	Line    int
 	message string
 	word    string
 }
@@ -204,5 +267,5 @@ func NewParseError(message, word string) *ParseError {
 }
 
 func (e *ParseError) Error() string {
-	return fmt.Sprintf("parse error: %s at %q", e.message, e.word)
+	return fmt.Sprintf("line %d: parse error: %s at %q", e.Line, e.message, e.word)
 }
@@ -1,4 +1,4 @@
-package main
+package asm
 
 import (
 	"bufio"
@@ -161,3 +161,18 @@ func TestIsValidSymbol(t *testing.T) {
 		})
 	}
 }
+
+func TestParserLine(t *testing.T) {
+	input := "// comment\n\n@R0\r\n  D=M // load\n\n(END)\n@END\n0;JMP"
+	wants := []int{3, 4, 6, 7, 8}
+
+	parser := NewParser(strings.NewReader(input))
+	for _, want := range wants {
+		if !parser.Parse() {
+			t.Fatalf("failed to parse line %d", want)
+		}
+		if got := parser.Line(); got != want {
+			t.Errorf("want line %d, but got %d for %v", want, got, parser.CurrentCommand())
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package asm
+
+import (
+	"fmt"
+	"sort"
+)
+
+type Address = uint16
+
+type SymbolKind int
+
+const (
+	PredefinedSymbol SymbolKind = iota
+	LabelSymbol                 // ROM address
+	VariableSymbol              // RAM address
+)
+
+var symbolKindNames = []string{"predefined", "label", "variable"}
+
+func (k SymbolKind) String() string {
+	return symbolKindNames[k]
+}
+
+func (k SymbolKind) MarshalText() ([]byte, error) {
+	return []byte(k.String()), nil
+}
+
+func (k *SymbolKind) UnmarshalText(text []byte) error {
+	for i, name := range symbolKindNames {
+		if name == string(text) {
+			*k = SymbolKind(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown symbol kind: %s", text)
+}
+
+type Symbol struct {
+	Name    string     `json:"name"`
+	Address Address    `json:"address"`
+	Kind    SymbolKind `json:"kind"`
+}
+
+type SymbolTable struct {
+	table          map[string]Address
+	kinds          map[string]SymbolKind
+	nextRAMAddress Address
+}
+
+func NewSymbolTable() *SymbolTable {
+	return &SymbolTable{
+		table: map[string]Address{
+			"SP":     0x0000,
+			"LCL":    0x0001,
+			"ARG":    0x0002,
+			"THIS":   0x0003,
+			"THAT":   0x0004,
+			"R0":     0x0000,
+			"R1":     0x0001,
+			"R2":     0x0002,
+			"R3":     0x0003,
+			"R4":     0x0004,
+			"R5":     0x0005,
+			"R6":     0x0006,
+			"R7":     0x0007,
+			"R8":     0x0008,
+			"R9":     0x0009,
+			"R10":    0x000a,
+			"R11":    0x000b,
+			"R12":    0x000c,
+			"R13":    0x000d,
+			"R14":    0x000e,
+			"R15":    0x000f,
+			"SCREEN": 0x4000,
+			"KBD":    0x6000,
+		},
+		kinds:          make(map[string]SymbolKind),
+		nextRAMAddress: 0x0010,
+	}
+}
+
+func (s *SymbolTable) GetAddress(key string) (value Address, ok bool) {
+	value, ok = s.table[key]
+	return
+}
+
+// AddEntry adds a label to table.
+func (s *SymbolTable) AddEntry(key string, value Address) {
+	s.table[key] = value
+	s.kinds[key] = LabelSymbol
+}
+
+// AddAutoEntry adds new entry to table and return its address.
+// The address of new entry is auto incremented value.
+func (s *SymbolTable) AddAutoEntry(key string) Address {
+	value := s.nextRAMAddress
+	s.table[key] = value
+	s.kinds[key] = VariableSymbol
+	s.nextRAMAddress++
+	return value
+}
+
+// Symbols returns labels and variables in the table.
+// Labels come first, and each kind is sorted by address.
+func (s *SymbolTable) Symbols() []Symbol {
+	var symbols []Symbol
+	for name, kind := range s.kinds {
+		symbols = append(symbols, Symbol{Name: name, Address: s.table[name], Kind: kind})
+	}
+	sort.Slice(symbols, func(i, j int) bool {
+		a, b := symbols[i], symbols[j]
+		if a.Kind != b.Kind {
+			return a.Kind < b.Kind
+		}
+		if a.Address != b.Address {
+			return a.Address < b.Address
+		}
+		return a.Name < b.Name
+	})
+	return symbols
+}
+
+func (s *SymbolTable) LoadLabelAddress(p *Parser) error {
+	var romAddr Address
+	for p.Parse() {
+		if cmd, ok := p.CurrentCommand().(*LCommand); ok {
+			s.AddEntry(cmd.Symbol, romAddr)
+		} else {
+			romAddr++
+		}
+	}
+	return p.Err()
+}
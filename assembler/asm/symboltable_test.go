@@ -0,0 +1,50 @@
+package asm
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSymbols(t *testing.T) {
+	input := `@i
+M=1
+(LOOP)
+@sum
+M=0
+(END)
+@LOOP
+0;JMP
+`
+	_, symbolTable, err := Assemble(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []Symbol{
+		{"LOOP", 2, LabelSymbol},
+		{"END", 4, LabelSymbol},
+		{"i", 16, VariableSymbol},
+		{"sum", 17, VariableSymbol},
+	}
+	got := symbolTable.Symbols()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %v, but got %v", want, got)
+	}
+
+	data, err := json.Marshal(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `{"name":"LOOP","address":2,"kind":"label"}`) {
+		t.Errorf("unexpected JSON: %s", data)
+	}
+	var decoded []Symbol
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded, want) {
+		t.Errorf("want %v, but got %v", want, decoded)
+	}
+}
@@ -0,0 +1,79 @@
+// Asmfmt formats Hack assembly.
+//
+// Without files, it formats stdin to stdout. Otherwise it prints
+// formatted files to stdout unless -w or -l is given.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"assembler/asm"
+)
+
+func main() {
+	write := flag.Bool("w", false, "write result to source file instead of stdout")
+	list := flag.Bool("l", false, "list files whose formatting differs from asmfmt's")
+	extended := flag.Bool("ext", false, "enable the extended instruction set (commutative comp spellings and shifts)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [FILE.asm]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	opts := asm.Options{Extended: *extended}
+
+	if flag.NArg() == 0 {
+		src, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			Die("failed to read stdin: %v", err)
+		}
+		formatted, err := asm.FormatWithOptions(src, opts)
+		if err != nil {
+			Die("<stdin>: %v", err)
+		}
+		os.Stdout.Write(formatted)
+		return
+	}
+
+	failed := false
+	for _, filename := range flag.Args() {
+		if err := formatFile(filename, opts, *write, *list); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func formatFile(filename string, opts asm.Options, write, list bool) error {
+	src, err := os.ReadFile(filename)
+	if err != nil {
+		return err
+	}
+	formatted, err := asm.FormatWithOptions(src, opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	changed := !bytes.Equal(src, formatted)
+	if list && changed {
+		fmt.Println(filename)
+	}
+	if write && changed {
+		return os.WriteFile(filename, formatted, 0o644)
+	}
+	if !write && !list {
+		os.Stdout.Write(formatted)
+	}
+	return nil
+}
+
+func Die(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -0,0 +1,154 @@
+// Package emulator runs Hack machine code.
+package emulator
+
+const (
+	ROMSize = 0x8000
+	RAMSize = 0x8000
+
+	SCREEN = 0x4000
+	KBD    = 0x6000
+)
+
+const (
+	jgt = 1 << iota
+	jeq
+	jlt
+	destM
+	destD
+	destA
+)
+
+// CPU is the Hack computer: CPU, ROM32K and memory.
+type CPU struct {
+	ROM [ROMSize]uint16
+	// RAM includes the memory maps of the screen and the keyboard.
+	RAM [RAMSize]uint16
+
+	A  uint16
+	D  uint16
+	PC uint16
+
+	// Cycles is the number of executed instructions.
+	Cycles uint64
+	// Extended enables the shift instructions of the extended instruction set.
+	// See asm.SHIFT_INSTRUCTION_MARKER for their encoding.
+	Extended bool
+}
+
+// New returns a CPU with program loaded into ROM.
+func New(program []uint16) *CPU {
+	cpu := &CPU{}
+	copy(cpu.ROM[:], program)
+	return cpu
+}
+
+// Step executes one instruction.
+func (c *CPU) Step() {
+	inst := c.ROM[c.PC]
+	c.Cycles++
+
+	// A-instruction
+	if inst&(1<<15) == 0 {
+		c.A = inst
+		c.PC++
+		return
+	}
+
+	// C-instruction
+	// The memory and the jump address are selected by A before execution.
+	addr := c.A % RAMSize
+	y := c.A
+	if inst&(1<<12) != 0 {
+		y = c.RAM[addr]
+	}
+	var out uint16
+	if c.Extended && inst>>13 == 0b101 {
+		out = shift(inst, c.D, y)
+	} else {
+		out = alu(inst, c.D, y)
+	}
+
+	if inst&destM != 0 {
+		c.RAM[addr] = out
+	}
+	if inst&destA != 0 {
+		c.A = out
+	}
+	if inst&destD != 0 {
+		c.D = out
+	}
+
+	if jumps(inst, int16(out)) {
+		c.PC = addr
+	} else {
+		c.PC++
+	}
+}
+
+// alu computes the comp part of a C-instruction with the control bits
+// zx, nx, zy, ny, f and no.
+func alu(inst, x, y uint16) uint16 {
+	if inst&(1<<11) != 0 { // zx
+		x = 0
+	}
+	if inst&(1<<10) != 0 { // nx
+		x = ^x
+	}
+	if inst&(1<<9) != 0 { // zy
+		y = 0
+	}
+	if inst&(1<<8) != 0 { // ny
+		y = ^y
+	}
+	var out uint16
+	if inst&(1<<7) != 0 { // f
+		out = x + y
+	} else {
+		out = x & y
+	}
+	if inst&(1<<6) != 0 { // no
+		out = ^out
+	}
+	return out
+}
+
+// shift computes the comp part of an extended shift instruction.
+func shift(inst, x, y uint16) uint16 {
+	operand := y
+	if inst&(1<<10) != 0 {
+		operand = x
+	}
+	if inst&(1<<11) != 0 {
+		return operand << 1
+	}
+	return uint16(int16(operand) >> 1)
+}
+
+func jumps(inst uint16, out int16) bool {
+	return (inst&jlt != 0 && out < 0) ||
+		(inst&jeq != 0 && out == 0) ||
+		(inst&jgt != 0 && out > 0)
+}
+
+// Halted reports whether the CPU is stuck in the conventional
+// `(END) @END 0;JMP` infinite loop which ends Hack programs.
+func (c *CPU) Halted() bool {
+	pc := c.PC
+	if pc+1 >= ROMSize {
+		return false
+	}
+	load, jump := c.ROM[pc], c.ROM[pc+1]
+	return load == pc && jump == 0b1110_1010_1000_0111 // @pc 0;JMP
+}
+
+// Run executes instructions until the program halts or maxCycles
+// instructions are executed. It reports whether the program halted.
+func (c *CPU) Run(maxCycles uint64) bool {
+	for i := uint64(0); i < maxCycles; i++ {
+		if c.Halted() {
+			return true
+		}
+		c.Step()
+	}
+	return c.Halted()
+}
@@ -0,0 +1,143 @@
+package emulator
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"assembler/asm"
+)
+
+func load(t *testing.T, src string, opts asm.Options) *CPU {
+	t.Helper()
+	program, _, err := asm.AssembleWithOptions(strings.NewReader(src), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := New(program)
+	cpu.Extended = opts.Extended
+	return cpu
+}
+
+func TestRunMult(t *testing.T) {
+	// R2 = R0 * R1
+	src := `
+   @R2
+   M=0
+(LOOP)
+   @R1
+   D=M
+   @END
+   D;JEQ
+   @R0
+   D=M
+   @R2
+   M=D+M
+   @R1
+   M=M-1
+   @LOOP
+   0;JMP
+(END)
+   @END
+   0;JMP
+`
+	cpu := load(t, src, asm.Options{})
+	cpu.RAM[0] = 7
+	cpu.RAM[1] = 6
+	if !cpu.Run(1000) {
+		t.Fatalf("program did not halt: PC=%d", cpu.PC)
+	}
+	if got := cpu.RAM[2]; got != 42 {
+		t.Errorf("want R2 to be 42, but got %d", got)
+	}
+}
+
+func TestALU(t *testing.T) {
+	const x, a = 5, 3 // D and A
+	var m int16 = -7  // RAM[A]
+	tests := map[string]int16{
+		"0": 0, "1": 1, "-1": -1,
+		"D": x, "A": a, "M": m,
+		"!D": ^x, "!A": ^a, "!M": ^m,
+		"-D": -x, "-A": -a, "-M": -m,
+		"D+1": x + 1, "A+1": a + 1, "M+1": m + 1,
+		"D-1": x - 1, "A-1": a - 1, "M-1": m - 1,
+		"D+A": x + a, "D-A": x - a, "A-D": a - x,
+		"D&A": x & a, "D|A": x | a,
+		"D+M": x + m, "D-M": x - m, "M-D": m - x,
+		"D&M": x & m, "D|M": x | m,
+	}
+
+	for comp, want := range tests {
+		t.Run(comp, func(t *testing.T) {
+			cpu := load(t, fmt.Sprintf("D=%s", comp), asm.Options{})
+			cpu.A = a
+			cpu.D = x
+			cpu.RAM[a] = uint16(m)
+			cpu.Step()
+			if got := int16(cpu.D); got != want {
+				t.Errorf("want %d, but got %d", want, got)
+			}
+		})
+	}
+}
+
+func TestJumpUsesOldA(t *testing.T) {
+	src := `
+   @4
+   A=1;JMP
+   @R0
+   M=1
+   @R1
+   M=1
+(END)
+   @END
+   0;JMP
+`
+	cpu := load(t, src, asm.Options{})
+	cpu.Run(100)
+	if cpu.RAM[0] != 0 || cpu.RAM[1] != 1 {
+		t.Errorf("want jump to ROM[4], but got R0=%d R1=%d", cpu.RAM[0], cpu.RAM[1])
+	}
+}
+
+func TestExtendedInstructions(t *testing.T) {
+	src := `
+   @R0
+   D=M
+   D=D<<
+   @R1
+   M=D
+   @R0
+   M=M>>
+   @R2
+   D=M
+   @R0
+   D=M+D  // commutative spelling of D+M
+   @R3
+   M=D
+(END)
+   @END
+   0;JMP
+`
+	cpu := load(t, src, asm.Options{Extended: true})
+	cpu.RAM[0] = uint16(0xfff6) // -10
+	cpu.RAM[2] = 3
+	if !cpu.Run(100) {
+		t.Fatal("program did not halt")
+	}
+	wants := []int16{-5, -20, 3, -2}
+	for i, want := range wants {
+		if got := int16(cpu.RAM[i]); got != want {
+			t.Errorf("want RAM[%d] to be %d, but got %d", i, want, got)
+		}
+	}
+}
+
+func TestExtendedInstructionsRequireExtendedMode(t *testing.T) {
+	for _, src := range []string{"D=D<<", "D=A+D"} {
+		if _, _, err := asm.Assemble(strings.NewReader(src)); err == nil {
+			t.Errorf("want error for %q without extended mode", src)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// OutputFormat is a serialization of assembled machine code.
+type OutputFormat struct {
+	// Ext is the extension of the output file.
+	Ext   string
+	write func(w io.Writer, words []uint16) error
+}
+
+// Write writes words to w in the format.
+func (f *OutputFormat) Write(w io.Writer, words []uint16) error {
+	bw := bufio.NewWriter(w)
+	if err := f.write(bw, words); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+var outputFormats = map[string]*OutputFormat{
+	// text is the course's ASCII format loaded by the CPU emulator.
+	"text": {Ext: ".hack", write: writeText},
+	// bin-le and bin-be are raw 16-bit words without any header.
+	"bin-le": {Ext: ".bin", write: writeBinary(binary.LittleEndian)},
+	"bin-be": {Ext: ".bin", write: writeBinary(binary.BigEndian)},
+	// ihex is Intel HEX with byte addresses and big-endian words.
+	"ihex": {Ext: ".hex", write: writeIntelHex},
+	// readmemb and readmemh are read by Verilog's $readmemb/$readmemh.
+	"readmemb": {Ext: ".mem", write: writeText},
+	"readmemh": {Ext: ".mem", write: writeReadmemh},
+}
+
+func writeText(w io.Writer, words []uint16) error {
+	for _, word := range words {
+		if _, err := fmt.Fprintf(w, "%016b\n", word); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeReadmemh(w io.Writer, words []uint16) error {
+	for _, word := range words {
+		if _, err := fmt.Fprintf(w, "%04x\n", word); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func writeBinary(order binary.ByteOrder) func(io.Writer, []uint16) error {
+	return func(w io.Writer, words []uint16) error {
+		return binary.Write(w, order, words)
+	}
+}
+
+const (
+	ihexRecordSize = 16
+
+	ihexData      = 0x00
+	ihexEndOfFile = 0x01
+)
+
+func writeIntelHex(w io.Writer, words []uint16) error {
+	data := make([]byte, 2*len(words))
+	for i, word := range words {
+		binary.BigEndian.PutUint16(data[2*i:], word)
+	}
+	// ROM32K is exactly 64KiB, so 16-bit record addresses are sufficient.
+	for addr := 0; addr < len(data); addr += ihexRecordSize {
+		end := min(addr+ihexRecordSize, len(data))
+		if err := writeIntelHexRecord(w, uint16(addr), ihexData, data[addr:end]); err != nil {
+			return err
+		}
+	}
+	return writeIntelHexRecord(w, 0, ihexEndOfFile, nil)
+}
+
+// writeIntelHexRecord writes `:LLAAAATT<data>CC`.
+func writeIntelHexRecord(w io.Writer, addr uint16, typ byte, data []byte) error {
+	sum := byte(len(data)) + byte(addr>>8) + byte(addr) + typ
+	for _, b := range data {
+		sum += b
+	}
+	checksum := -sum
+	_, err := fmt.Fprintf(w, ":%02X%04X%02X%X%02X\n", len(data), addr, typ, data, checksum)
+	return err
+}
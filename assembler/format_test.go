@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestOutputFormats(t *testing.T) {
+	words := []uint16{0x0002, 0xec10}
+
+	tests := []struct {
+		format string
+		want   string
+	}{
+		{"text", "0000000000000010\n1110110000010000\n"},
+		{"bin-le", "\x02\x00\x10\xec"},
+		{"bin-be", "\x00\x02\xec\x10"},
+		{"ihex", ":040000000002EC10FE\n:00000001FF\n"},
+		{"readmemb", "0000000000000010\n1110110000010000\n"},
+		{"readmemh", "0002\nec10\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := outputFormats[tt.format].Write(&buf, words); err != nil {
+				t.Fatal(err)
+			}
+			if got := buf.String(); got != tt.want {
+				t.Errorf("want %q, but got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestIntelHexSplitsRecords(t *testing.T) {
+	words := make([]uint16, 9)
+	var buf bytes.Buffer
+	if err := outputFormats["ihex"].Write(&buf, words); err != nil {
+		t.Fatal(err)
+	}
+	want := ":1000000000000000000000000000000000000000F0\n" +
+		":020010000000EE\n" +
+		":00000001FF\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q, but got %q", want, got)
+	}
+}
@@ -1,67 +1,173 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+
+	"assembler/asm"
 )
 
-func assertGivenFileIsAssembly(filename string) {
-	if filepath.Ext(filename) != ".asm" {
-		Die("File must be .asm file")
-	}
-}
+// stdio is the filename which means stdin for input and stdout for output.
+const stdio = "-"
 
 func main() {
+	formatName := flag.String("format", "text", "output format: text, bin-le, bin-be, ihex, readmemb or readmemh")
+	wall := flag.Bool("Wall", false, "warn about suspicious but legal code")
+	output := flag.String("o", "", "output file (- for stdout); only valid with a single input")
+	extended := flag.Bool("ext", false, "enable the extended instruction set (commutative comp spellings and shifts)")
+	sym := flag.Bool("sym", false, "write labels and variables to NAME.sym and NAME.sym.json next to the output")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [FILE.asm | DIR | -]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	filename := flag.Arg(0)
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	assertGivenFileIsAssembly(filename)
+	format, ok := outputFormats[*formatName]
+	if !ok {
+		Die("unknown output format: %s", *formatName)
+	}
 
-	asmFile, err := os.Open(filename)
+	inputs, err := expandInputs(flag.Args())
 	if err != nil {
-		Die("failed to open asm file: %v", err)
+		Die("%v", err)
+	}
+	if *output != "" && len(inputs) > 1 {
+		Die("-o cannot be used with multiple input files")
+	}
+	if *sym && (*output == stdio || (*output == "" && slices.Contains(inputs, stdio))) {
+		Die("-sym cannot be used when writing to stdout")
 	}
-	defer asmFile.Close()
-
-	// 1pass
-	parser := NewParser(asmFile)
-	symbolTable := NewSymbolTable()
-	symbolTable.LoadLabelAddress(parser)
 
-	hackFilename := strings.TrimSuffix(filename, filepath.Ext(filename)) + ".hack"
-	hackFile, err := os.Create(hackFilename)
-	if err != nil {
-		Die("failed to create hack file: %v", err)
+	failed := false
+	for _, input := range inputs {
+		outFilename := *output
+		if outFilename == "" {
+			outFilename = outputFilename(input, format)
+		}
+		if err := assembleFile(input, outFilename, format, asm.Options{Extended: *extended}, *wall, *sym); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			failed = true
+		}
 	}
-	defer hackFile.Close()
+	if failed {
+		os.Exit(1)
+	}
+}
 
-	// 2pass
-	asmFile.Seek(0, io.SeekStart)
-	parser = NewParser(asmFile)
-	for parser.Parse() {
-		var bin uint16
-		var err error
-		switch cmd := parser.CurrentCommand().(type) {
-		case *ACommand:
-			if cmd.SymbolIsDigit {
-				bin, err = ConvertACommand(cmd)
-			} else if addr, ok := symbolTable.GetAddress(cmd.Symbol); ok {
-				bin = addr
-			} else {
-				bin = symbolTable.AddAutoEntry(cmd.Symbol)
+// expandInputs replaces directories in args with .asm files in them and
+// drops files given more than once, which would be assembled into the same
+// output. Stdin can be given only once, as reading it again would see it
+// empty.
+func expandInputs(args []string) ([]string, error) {
+	var inputs []string
+	seen := make(map[string]bool)
+	add := func(input string) {
+		if key := filepath.Clean(input); !seen[key] {
+			seen[key] = true
+			inputs = append(inputs, input)
+		}
+	}
+	readsStdin := false
+	for _, arg := range args {
+		if arg == stdio {
+			if readsStdin {
+				return nil, fmt.Errorf("%s cannot be given more than once", stdio)
 			}
-		case *CCommand:
-			bin, err = ConvertCCommand(cmd)
-		default:
+			readsStdin = true
+			inputs = append(inputs, arg)
 			continue
 		}
+		info, err := os.Stat(arg)
 		if err != nil {
-			Die("failed to convert command to binary: %v", err)
+			return nil, fmt.Errorf("cannot stat %s: %w", arg, err)
+		}
+		if !info.IsDir() {
+			if filepath.Ext(arg) != ".asm" {
+				return nil, fmt.Errorf("file must be .asm file: %s", arg)
+			}
+			add(arg)
+			continue
 		}
-		fmt.Fprintf(hackFile, "%016b\n", bin)
+		asmFiles, err := filepath.Glob(filepath.Join(arg, "*.asm"))
+		if err != nil {
+			return nil, fmt.Errorf("cannot list asm files in %s: %w", arg, err)
+		}
+		if len(asmFiles) == 0 {
+			return nil, fmt.Errorf("no asm files in %s", arg)
+		}
+		for _, asmFile := range asmFiles {
+			add(asmFile)
+		}
+	}
+	return inputs, nil
+}
+
+func outputFilename(input string, format *OutputFormat) string {
+	if input == stdio {
+		return stdio
+	}
+	return strings.TrimSuffix(input, filepath.Ext(input)) + format.Ext
+}
+
+func assembleFile(filename, outFilename string, format *OutputFormat, opts asm.Options, wall, sym bool) error {
+	var src []byte
+	var err error
+	if filename == stdio {
+		src, err = io.ReadAll(os.Stdin)
+		filename = "<stdin>"
+	} else {
+		src, err = os.ReadFile(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read asm file: %w", err)
+	}
+
+	words, symbolTable, err := asm.AssembleWithOptions(bytes.NewReader(src), opts)
+	if err != nil {
+		return fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if wall {
+		parser := asm.NewParser(bytes.NewReader(src))
+		parser.SetExtended(opts.Extended)
+		warnings, err := asm.Lint(parser)
+		if err != nil {
+			return fmt.Errorf("%s: %w", filename, err)
+		}
+		for _, w := range warnings {
+			fmt.Fprintf(os.Stderr, "%s:%d: warning: %s\n", filename, w.Line, w.Message)
+		}
+	}
+
+	if sym {
+		base := strings.TrimSuffix(outFilename, filepath.Ext(outFilename))
+		if err := writeSymbolFiles(base, symbolTable.Symbols()); err != nil {
+			return err
+		}
+	}
+
+	if outFilename == stdio {
+		return format.Write(os.Stdout, words)
+	}
+
+	hackFile, err := os.Create(outFilename)
+	if err != nil {
+		return fmt.Errorf("failed to create hack file: %w", err)
+	}
+	defer hackFile.Close()
+
+	if err := format.Write(hackFile, words); err != nil {
+		return fmt.Errorf("failed to write hack file: %w", err)
 	}
+	return nil
 }
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestExpandInputs(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"b.asm", "a.asm", "c.hack"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	single := filepath.Join(dir, "b.asm")
+
+	got, err := expandInputs([]string{stdio, dir, single})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{stdio, filepath.Join(dir, "a.asm"), single}
+	if !slices.Equal(got, want) {
+		t.Errorf("want %v, but got %v", want, got)
+	}
+
+	if _, err := expandInputs([]string{stdio, single, stdio}); err == nil {
+		t.Error("want an error for stdin given twice")
+	}
+	if _, err := expandInputs([]string{filepath.Join(dir, "c.hack")}); err == nil {
+		t.Error("want an error for a file which is not .asm")
+	}
+}
+
+func TestOutputFilename(t *testing.T) {
+	tests := []struct {
+		input  string
+		format string
+		want   string
+	}{
+		{"prog/Max.asm", "text", "prog/Max.hack"},
+		{"prog/Max.asm", "ihex", "prog/Max.hex"},
+		{stdio, "bin-le", stdio},
+	}
+
+	for _, tt := range tests {
+		if got := outputFilename(tt.input, outputFormats[tt.format]); got != tt.want {
+			t.Errorf("want %q, but got %q", tt.want, got)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"assembler/asm"
+)
+
+// writeSymbolsText writes a symbol per line as `name address kind`.
+func writeSymbolsText(w io.Writer, symbols []asm.Symbol) error {
+	bw := bufio.NewWriter(w)
+	for _, s := range symbols {
+		fmt.Fprintf(bw, "%s %d %s\n", s.Name, s.Address, s.Kind)
+	}
+	return bw.Flush()
+}
+
+func writeSymbolsJSON(w io.Writer, symbols []asm.Symbol) error {
+	if symbols == nil {
+		symbols = []asm.Symbol{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(symbols)
+}
+
+// writeSymbolFiles writes base.sym and base.sym.json.
+func writeSymbolFiles(base string, symbols []asm.Symbol) error {
+	for ext, write := range map[string]func(io.Writer, []asm.Symbol) error{
+		".sym":      writeSymbolsText,
+		".sym.json": writeSymbolsJSON,
+	} {
+		f, err := os.Create(base + ext)
+		if err != nil {
+			return fmt.Errorf("failed to create symbol file: %w", err)
+		}
+		err = write(f, symbols)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write symbol file: %w", err)
+		}
+	}
+	return nil
+}
@@ -12,6 +12,7 @@ type CompilationEngine struct {
 	tokenizer *Tokenizer
 	vmwriter  *VMWriter
 	symtable  *SymbolTable
+	extended  bool
 
 	currentToken Token
 	peekToken    Token
@@ -27,6 +28,12 @@ func NewCompilationEngine(input io.Reader, out io.Writer) *CompilationEngine {
 	return &engine
 }
 
+// SetExtended makes * and / compile to the extended VM commands mul and div
+// instead of calls to Math.multiply and Math.divide.
+func (e *CompilationEngine) SetExtended(extended bool) {
+	e.extended = extended
+}
+
 func (e *CompilationEngine) SetInput(input io.Reader) {
 	e.tokenizer = NewTokenizer(input)
 }
@@ -385,11 +392,19 @@ func (e *CompilationEngine) compileExpression() {
 	case TokenAsterisk:
 		e.nextToken()
 		e.compileExpression()
-		e.vmwriter.WriteCall("Math.multiply", 2)
+		if e.extended {
+			e.vmwriter.WriteArithmeric(CmdMul)
+		} else {
+			e.vmwriter.WriteCall("Math.multiply", 2)
+		}
 	case TokenSlash:
 		e.nextToken()
 		e.compileExpression()
-		e.vmwriter.WriteCall("Math.divide", 2)
+		if e.extended {
+			e.vmwriter.WriteArithmeric(CmdDiv)
+		} else {
+			e.vmwriter.WriteCall("Math.divide", 2)
+		}
 	case TokenAmpersand:
 		e.nextToken()
 		e.compileExpression()
@@ -8,6 +8,7 @@ import (
 )
 
 func main() {
+	extended := flag.Bool("ext", false, "compile * and / to the extended VM commands mul and div")
 	flag.Parse()
 	if flag.NArg() < 1 {
 		Die("Usage: %s [FILE | DIR]", os.Args[0])
@@ -24,10 +25,10 @@ func main() {
 			Die("jack files not found: %v", err)
 		}
 		for _, jackFile := range jackFiles {
-			compileFile(jackFile)
+			compileFile(jackFile, *extended)
 		}
 	} else {
-		compileFile(path)
+		compileFile(path, *extended)
 	}
 }
 
@@ -36,7 +37,7 @@ func outFilename(inFilename string) string {
 	return strings.TrimSuffix(inFilename, ext) + ".vm"
 }
 
-func compileFile(path string) {
+func compileFile(path string, extended bool) {
 	jackFile, err := os.Open(path)
 	if err != nil {
 		Die("cannot open %s: %v", path, err)
@@ -50,5 +51,6 @@ func compileFile(path string) {
 	defer vmFile.Close()
 
 	engine := NewCompilationEngine(jackFile, vmFile)
+	engine.SetExtended(extended)
 	engine.Compile()
 }
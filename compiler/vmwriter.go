@@ -30,6 +30,10 @@ const (
 	CmdAnd ArithmeticCommand = "and"
 	CmdOr  ArithmeticCommand = "or"
 	CmdNot ArithmeticCommand = "not"
+
+	// Extended commands, which the VM translator accepts with -ext.
+	CmdMul ArithmeticCommand = "mul"
+	CmdDiv ArithmeticCommand = "div"
 )
 
 type VMWriter struct {
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"vmtranslator/vm"
+)
+
+// osDirName is the directory of the Jack OS searched upwards from the input.
+var osDirName = filepath.Join("tools", "OS")
+
+// collectVMFiles replaces directories in args with .vm files in them and
+// returns the files in translation order. See sortVMFiles.
+func collectVMFiles(args []string) ([]string, error) {
+	var paths []string
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			if filepath.Ext(arg) != ".vm" {
+				return nil, fmt.Errorf("not a .vm file or directory: %s", arg)
+			}
+			paths = append(paths, arg)
+			continue
+		}
+		vmFiles, err := filepath.Glob(filepath.Join(arg, "*.vm"))
+		if err != nil {
+			return nil, err
+		}
+		if len(vmFiles) == 0 {
+			return nil, fmt.Errorf("no vm files in %s", arg)
+		}
+		paths = append(paths, vmFiles...)
+	}
+	return sortVMFiles(paths)
+}
+
+// sortVMFiles sorts files by vm.CompareFileNames. Files with the same name
+// would share statics and labels, so they are rejected unless they are the
+// same file.
+func sortVMFiles(paths []string) ([]string, error) {
+	byName := make(map[string]string)
+	var sorted []string
+	for _, path := range paths {
+		name := filepath.Base(path)
+		prev, ok := byName[name]
+		if !ok {
+			byName[name] = path
+			sorted = append(sorted, path)
+			continue
+		}
+		if !sameFile(prev, path) {
+			return nil, fmt.Errorf("%s and %s have the same name", prev, path)
+		}
+	}
+	slices.SortFunc(sorted, func(a, b string) int {
+		return vm.CompareFileNames(filepath.Base(a), filepath.Base(b))
+	})
+	return sorted, nil
+}
+
+func sameFile(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
+
+// defaultOutputFilename returns X.asm for X.vm, and DIR/DIR.asm for DIR.
+// It returns "" for multiple inputs.
+func defaultOutputFilename(args []string) string {
+	if len(args) != 1 {
+		return ""
+	}
+	path := args[0]
+	if filepath.Ext(path) == ".vm" {
+		return strings.TrimSuffix(path, ".vm") + ".asm"
+	}
+	abspath, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(path, filepath.Base(abspath)+".asm")
+}
+
+// findOSDir returns the tools/OS directory in the nearest ancestor of path,
+// or "" if there is none.
+func findOSDir(path string) string {
+	dir, err := filepath.Abs(path)
+	if err != nil {
+		return ""
+	}
+	for {
+		candidate := filepath.Join(dir, osDirName)
+		if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+			return candidate
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// includeOS adds files of the OS in osDir which define functions called
+// but not defined by the program, including the ones they call in turn.
+// An OS file is only added if the program has no file of the same name.
+// Programs defining Main.main but not Sys.init are Jack programs, which
+// need Sys.init of the OS to start.
+func includeOS(paths []string, osDir string) ([]string, error) {
+	names := make(map[string]bool)
+	defined := make(map[string]bool)
+	called := make(map[string]bool)
+	scan := func(path string) error {
+		names[filepath.Base(path)] = true
+		return scanFunctions(path, defined, called)
+	}
+	for _, path := range paths {
+		if err := scan(path); err != nil {
+			return nil, err
+		}
+	}
+	if defined["Main.main"] && !defined["Sys.init"] {
+		called["Sys.init"] = true
+	}
+
+	for {
+		added := false
+		for funcName := range called {
+			if defined[funcName] {
+				continue
+			}
+			className, _, ok := strings.Cut(funcName, ".")
+			name := className + ".vm"
+			if !ok || names[name] {
+				continue
+			}
+			path := filepath.Join(osDir, name)
+			if _, err := os.Stat(path); err != nil {
+				names[name] = true // not an OS class
+				continue
+			}
+			if err := scan(path); err != nil {
+				return nil, err
+			}
+			paths = append(paths, path)
+			added = true
+		}
+		if !added {
+			return sortVMFiles(paths)
+		}
+	}
+}
+
+// scanFunctions records functions defined and called in a VM file.
+// It ignores malformed commands, which are reported by vm.LoadProgram.
+func scanFunctions(path string, defined, called map[string]bool) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	p := vm.NewParser(in)
+	for p.HasMoreCommands() {
+		p.Advance()
+		fields := p.Fields()
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "function":
+			defined[fields[1]] = true
+		case "call":
+			called[fields[1]] = true
+		}
+	}
+	return nil
+}
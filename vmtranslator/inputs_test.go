@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFiles writes files into a temporary directory and returns their paths.
+func writeFiles(t *testing.T, files map[string]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	var paths []string
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+	return paths
+}
+
+func TestCollectVMFiles(t *testing.T) {
+	dir := filepath.Dir(writeFiles(t, map[string]string{
+		"Main.vm":  "",
+		"Sys.vm":   "",
+		"Array.vm": "",
+	})[0])
+	other := writeFiles(t, map[string]string{"Board.vm": ""})[0]
+
+	paths, err := collectVMFiles([]string{other, dir, filepath.Join(dir, "Main.vm")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, path := range paths {
+		names = append(names, filepath.Base(path))
+	}
+	want := []string{"Sys.vm", "Array.vm", "Board.vm", "Main.vm"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("got %v, want %v", names, want)
+	}
+
+	another := writeFiles(t, map[string]string{"Main.vm": ""})[0]
+	if _, err := collectVMFiles([]string{dir, another}); err == nil {
+		t.Error("want error for files with the same name")
+	}
+}
+
+func TestDefaultOutputFilename(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"dir/Prog.vm"}, "dir/Prog.asm"},
+		{[]string{"../projects/07/StackArithmetic/SimpleAdd"}, "../projects/07/StackArithmetic/SimpleAdd/SimpleAdd.asm"},
+		{[]string{"a.vm", "b.vm"}, ""},
+	}
+	for _, tt := range tests {
+		if got := defaultOutputFilename(tt.args); got != tt.want {
+			t.Errorf("defaultOutputFilename(%v) = %q, want %q", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestFindOSDir(t *testing.T) {
+	want, err := filepath.Abs("../tools/OS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := findOSDir("../projects/09/hilow/Main.vm"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := findOSDir(t.TempDir()); got != "" {
+		t.Errorf("got %q for a directory outside the repository", got)
+	}
+}
+
+func TestIncludeOS(t *testing.T) {
+	paths, err := filepath.Glob("../projects/07/StackArithmetic/SimpleAdd/*.vm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := includeOS(paths, "../tools/OS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, paths) {
+		t.Errorf("want no OS files for a program without calls, got %v", got)
+	}
+
+	// Sys.init of the OS calls Main.main and Main.main calls Math.multiply.
+	paths = writeFiles(t, map[string]string{"Main.vm": `
+function Main.main 0
+push constant 6
+push constant 7
+call Math.multiply 2
+return
+`})
+	got, err = includeOS(paths, "../tools/OS")
+	if err != nil {
+		t.Fatal(err)
+	}
+	names := make(map[string]bool)
+	for _, path := range got {
+		names[filepath.Base(path)] = true
+	}
+	if filepath.Base(got[0]) != "Sys.vm" || !names["Math.vm"] || !names["Main.vm"] {
+		t.Errorf("want Sys.vm first followed by Math.vm and Main.vm, got %v", got)
+	}
+}
@@ -1,98 +1,206 @@
 package main
 
 import (
+	"bytes"
 	"flag"
+	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
+
+	"assembler/asm"
+	"assembler/emulator"
+	"vmtranslator/profile"
+	"vmtranslator/vm"
 )
 
+// noOS is the value of -os which disables the inclusion of OS files.
+const noOS = "none"
+
+// tailCallModes are the values of -tco.
+var tailCallModes = map[string]vm.TailCallMode{
+	"":     vm.NoTailCalls,
+	"self": vm.SelfTailCalls,
+	"all":  vm.AllTailCalls,
+}
+
 func main() {
+	optimize := flag.Bool("O", false, "optimize generated code for size and speed")
+	compact := flag.Bool("compact", false, "use shared routines for comparisons, calls and returns to reduce code size")
+	cacheTop := flag.Bool("tos", false, "keep the top of the stack in D within basic blocks instead of RAM")
+	tailCalls := flag.String("tco", "", "reuse the frame of the caller for calls followed by return: \"self\" for recursive calls, \"all\" for any calls")
+	exactCompare := flag.Bool("exact-compare", false, "check signs of operands before subtracting so that gt, lt, le and ge are correct when x - y overflows")
+	extended := flag.Bool("ext", false, "enable extended commands: mul, div, shl, shr, le, ge, ne, dup and swap")
+	checked := flag.Bool("checked", false, "trap with a marker in RAM[15] when the stack grows into the heap")
+	checkedPointers := flag.Bool("checked-pointers", false, "trap with a marker in RAM[15] when this or that access outside the heap and the memory maps")
+	goBackend := flag.Bool("go", false, "write a Go program simulating the Hack RAM instead of Hack assembly; default output is NAME.go")
+	noBootstrap := flag.Bool("no-bootstrap", false, "never write bootstrap code; by default it is written only if Sys.init is defined")
+	output := flag.String("o", "", "output file; required with multiple inputs")
+	dce := flag.Bool("dce", false, "remove functions unreachable from the code running first (Sys.init with bootstrap code) and report them; with -inline, this removes functions inlined everywhere")
+	inline := flag.Int("inline", 0, "inline leaf functions of up to `N` commands; 0 disables inlining")
+	debug := flag.Bool("g", false, "write the VM file, line and function of each ROM address to NAME.map next to the output")
+	size := flag.Bool("size", false, "report instructions per function and file, and fail if they exceed the ROM")
+	profiling := flag.Bool("profile", false, "run the program on the CPU emulator and report calls and cycles per function")
+	profileCycles := flag.Uint64("cycles", 10_000_000, "maximum `N` cycles to run with -profile")
+	pprofFilename := flag.String("pprof", "", "write a gzipped pprof profile to `FILE` with -profile")
+	osDir := flag.String("os", "", "directory of OS .vm files to include when called but not defined (default: nearest tools/OS, \"none\" to disable)")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] (FILE.vm | DIR)...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
 	flag.Parse()
-	path := flag.Arg(0)
+	if flag.NArg() < 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
 
-	info, err := os.Stat(path)
-	if err != nil {
-		Die("cannot stat %s", path)
+	tailCallMode, ok := tailCallModes[*tailCalls]
+	if !ok {
+		Die("unknown -tco mode %s", *tailCalls)
 	}
 
-	var asmFilename string
+	if *goBackend && (*optimize || *compact || *cacheTop || tailCallMode != vm.NoTailCalls || *checked || *checkedPointers || *debug || *size || *profiling) {
+		Die("-go cannot be used with -O, -compact, -tos, -tco, -checked, -checked-pointers, -g, -size or -profile")
+	}
 
-	if info.IsDir() {
-		abspath, err := filepath.Abs(path)
-		if err != nil {
-			Die("cannot get absolute path for %s", info.Name())
+	asmFilename := *output
+	if asmFilename == "" {
+		asmFilename = defaultOutputFilename(flag.Args())
+		if asmFilename == "" {
+			Die("-o is required with multiple inputs")
 		}
-		asmFilename = filepath.Join(path, filepath.Base(abspath)+".asm")
+		if *goBackend {
+			asmFilename = strings.TrimSuffix(asmFilename, ".asm") + ".go"
+		}
+	}
 
-		entries, err := os.ReadDir(path)
+	vmPaths, err := collectVMFiles(flag.Args())
+	if err != nil {
+		Die("%v", err)
+	}
+	if *osDir == "" {
+		*osDir = findOSDir(flag.Arg(0))
+	}
+	if *osDir != "" && *osDir != noOS {
+		vmPaths, err = includeOS(vmPaths, *osDir)
 		if err != nil {
-			Die("cannot read dir %s", path)
+			Die("cannot include OS files: %v", err)
 		}
+	}
 
-		out, err := os.Create(asmFilename)
-		if err != nil {
-			Die("cannot create %s: %v", asmFilename, err)
+	opts := vm.Options{
+		Optimize:          *optimize,
+		Compact:           *compact,
+		CacheTop:          *cacheTop,
+		TailCalls:         tailCallMode,
+		ExactCompare:      *exactCompare,
+		Extended:          *extended,
+		Checked:           *checked,
+		CheckedPointers:   *checkedPointers,
+		NoBootstrap:       *noBootstrap,
+		Inline:            *inline,
+		Go:                *goBackend,
+		RemoveUnreachable: *dce,
+	}
+	prog, err := vm.LoadProgram(vmPaths, opts.Extended)
+	if err != nil {
+		Die("%v", err)
+	}
+	bootstrap, inlined, removed := prog.Prepare(opts)
+	for _, funcName := range inlined {
+		fmt.Fprintf(os.Stderr, "inlined function %s\n", funcName)
+	}
+	for _, funcName := range removed {
+		fmt.Fprintf(os.Stderr, "removed unreachable function %s\n", funcName)
+	}
+
+	out, err := os.Create(asmFilename)
+	if err != nil {
+		Die("cannot create %s: %v", asmFilename, err)
+	}
+	defer out.Close()
+
+	if opts.Go {
+		goWriter := vm.NewGoWriter(out)
+		goWriter.SetOptions(opts)
+		if err := goWriter.WriteProgram(prog, bootstrap); err != nil {
+			out.Close()
+			Die("cannot translate: %v", err)
 		}
-		codeWriter := NewCodeWriter(out)
-		defer out.Close()
-
-		codeWriter.WriteInit()
-		for _, e := range entries {
-			name := e.Name()
-			if filepath.Ext(name) != ".vm" {
-				continue
-			}
-			translateVM(filepath.Join(abspath, name), codeWriter)
+		return
+	}
+
+	var asmSrc bytes.Buffer
+	var asmOut io.Writer = out
+	if *profiling {
+		asmOut = io.MultiWriter(out, &asmSrc)
+	}
+	codeWriter := vm.NewCodeWriter(asmOut)
+	codeWriter.SetOptions(opts)
+	if err := vm.TranslateProgram(prog, codeWriter, bootstrap); err != nil {
+		out.Close()
+		Die("cannot translate: %v", err)
+	}
+
+	if *debug {
+		if err := writeSourceMapFile(strings.TrimSuffix(asmFilename, filepath.Ext(asmFilename))+".map", codeWriter.SourceMap()); err != nil {
+			out.Close()
+			Die("cannot write source map: %v", err)
 		}
-	} else {
-		ext := filepath.Ext(path)
-		if ext != ".vm" {
-			Die("please give .vm file or directory")
+	}
+
+	if *size {
+		if total := vm.WriteSizeReport(os.Stdout, codeWriter.Sizes()); total > vm.ROMWords {
+			out.Close()
+			Die("program does not fit into ROM: %d words over", total-vm.ROMWords)
 		}
-		asmFilename = strings.TrimSuffix(path, ext) + ".asm"
+	}
 
-		out, err := os.Create(asmFilename)
-		if err != nil {
-			Die("cannot create %s: %v", asmFilename, err)
+	if *profiling {
+		if err := runProfile(prog, &asmSrc, codeWriter.TailCallJumps(), *profileCycles, *pprofFilename); err != nil {
+			out.Close()
+			Die("cannot profile: %v", err)
 		}
-		codeWriter := NewCodeWriter(out)
-		defer out.Close()
+	}
+}
 
-		codeWriter.WriteInit()
-		translateVM(path, codeWriter)
+// runProfile runs the translated program and reports its profile.
+func runProfile(prog *vm.Program, src io.Reader, tailCallJumps []int, maxCycles uint64, pprofFilename string) error {
+	program, symbols, err := asm.Assemble(src)
+	if err != nil {
+		return err
+	}
+	profiler := profile.New(profile.Functions(prog, symbols))
+	profiler.SetTailCallJumps(tailCallJumps)
+	if !profiler.Run(emulator.New(program), maxCycles) {
+		fmt.Printf("stopped after %d cycles\n", maxCycles)
+	}
+	if err := profiler.WriteReport(os.Stdout); err != nil {
+		return err
+	}
+	if pprofFilename == "" {
+		return nil
 	}
+	f, err := os.Create(pprofFilename)
+	if err != nil {
+		return err
+	}
+	if err := profiler.WritePprof(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
 }
 
-func translateVM(vmPath string, w *CodeWriter) {
-	in, err := os.Open(vmPath)
+func writeSourceMapFile(filename string, locs []vm.SourceLocation) error {
+	f, err := os.Create(filename)
 	if err != nil {
-		Die("cannot open %s", vmPath)
-	}
-	defer in.Close()
-
-	w.SetFilename(filepath.Base(vmPath))
-
-	p := NewParser(in)
-	for p.HasMoreCommands() {
-		p.Advance()
-		switch typ := p.CommandType(); typ {
-		case C_ARITHMETIC:
-			w.WriteArithmetic(p.Arg1())
-		case C_PUSH, C_POP:
-			w.WritePushPop(typ, p.Arg1(), p.Arg2())
-		case C_LABEL:
-			w.WriteLabel(p.Arg1())
-		case C_GOTO:
-			w.WriteGoto(p.Arg1())
-		case C_IF:
-			w.WriteIf(p.Arg1())
-		case C_CALL:
-			w.WriteCall(p.Arg1(), p.Arg2())
-		case C_FUNCTION:
-			w.WriteFunction(p.Arg1(), p.Arg2())
-		case C_RETURN:
-			w.WriteReturn()
-		}
+		return err
+	}
+	if err := vm.WriteSourceMap(f, locs); err != nil {
+		f.Close()
+		return err
 	}
+	return f.Close()
 }
@@ -0,0 +1,144 @@
+package profile
+
+import (
+	"compress/gzip"
+	"io"
+	"slices"
+	"strings"
+)
+
+// This file writes profiles in the gzipped protocol buffer format of pprof,
+// github.com/google/pprof/proto/profile.proto. Only the fields used here
+// are encoded, by hand, to avoid the dependency.
+
+// Field numbers of profile.proto.
+const (
+	profileSampleType  = 1
+	profileSample      = 2
+	profileLocation    = 4
+	profileFunction    = 5
+	profileStringTable = 6
+	profilePeriodType  = 11
+	profilePeriod      = 12
+
+	valueTypeType = 1
+	valueTypeUnit = 2
+
+	sampleLocationID = 1
+	sampleValue      = 2
+
+	locationID   = 1
+	locationLine = 4
+
+	lineFunctionID = 1
+	lineLine       = 2
+
+	functionID         = 1
+	functionName       = 2
+	functionSystemName = 3
+	functionFilename   = 4
+	functionStartLine  = 5
+)
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+type protoMessage []byte
+
+func (m protoMessage) varint(v uint64) protoMessage {
+	for v >= 0x80 {
+		m = append(m, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(m, byte(v))
+}
+
+func (m protoMessage) uint(field int, v uint64) protoMessage {
+	return m.varint(uint64(field)<<3 | wireVarint).varint(v)
+}
+
+func (m protoMessage) bytes(field int, b []byte) protoMessage {
+	m = m.varint(uint64(field)<<3 | wireBytes).varint(uint64(len(b)))
+	return append(m, b...)
+}
+
+func (m protoMessage) packed(field int, vs []uint64) protoMessage {
+	var b protoMessage
+	for _, v := range vs {
+		b = b.varint(v)
+	}
+	return m.bytes(field, b)
+}
+
+// stringTable interns strings of a profile, where index 0 must be "".
+type stringTable struct {
+	strings []string
+	index   map[string]uint64
+}
+
+func (t *stringTable) add(s string) uint64 {
+	if i, ok := t.index[s]; ok {
+		return i
+	}
+	i := uint64(len(t.strings))
+	t.strings = append(t.strings, s)
+	t.index[s] = i
+	return i
+}
+
+// WritePprof writes cycles per call stack as a gzipped pprof profile,
+// which `go tool pprof` reads. Each function has one location.
+func (p *Profiler) WritePprof(out io.Writer) error {
+	strs := &stringTable{index: make(map[string]uint64)}
+	strs.add("")
+	valueType := protoMessage(nil).uint(valueTypeType, strs.add("cycles")).uint(valueTypeUnit, strs.add("count"))
+
+	var m protoMessage
+	m = m.bytes(profileSampleType, valueType)
+
+	ids := make(map[string]uint64)
+	for i, f := range p.functions {
+		id := uint64(i + 1)
+		ids[f.Name] = id
+		name := strs.add(f.Name)
+		function := protoMessage(nil).
+			uint(functionID, id).
+			uint(functionName, name).
+			uint(functionSystemName, name).
+			uint(functionFilename, strs.add(f.File)).
+			uint(functionStartLine, uint64(f.Line))
+		line := protoMessage(nil).uint(lineFunctionID, id).uint(lineLine, uint64(f.Line))
+		location := protoMessage(nil).uint(locationID, id).bytes(locationLine, line)
+		m = m.bytes(profileFunction, function).bytes(profileLocation, location)
+	}
+
+	keys := make([]string, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		names := strings.Split(key, ";")
+		var locations []uint64
+		for i := len(names) - 1; i >= 0; i-- { // the leaf first
+			locations = append(locations, ids[names[i]])
+		}
+		sample := protoMessage(nil).
+			packed(sampleLocationID, locations).
+			packed(sampleValue, []uint64{p.samples[key]})
+		m = m.bytes(profileSample, sample)
+	}
+
+	m = m.bytes(profilePeriodType, valueType).uint(profilePeriod, 1)
+	for _, s := range strs.strings {
+		m = m.bytes(profileStringTable, []byte(s))
+	}
+
+	zw := gzip.NewWriter(out)
+	if _, err := zw.Write(m); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,159 @@
+// Package profile attributes cycles of translated VM programs running on
+// the Hack emulator to VM functions.
+//
+// Calls are found by their effect on the machine rather than by the code,
+// so that every code generation mode is profiled alike: a function is
+// entered when PC is at its entry with LCL equal to SP, as call leaves them,
+// and it returns when PC is at the return address saved in its frame with
+// SP next to the return value at ARG, or LCL of the caller restored. PC
+// alone is not enough, since the caller may jump to its return address.
+//
+// A tail call reuses the frame of the caller and replaces the caller on the
+// call stack. It is told apart from a jump to a label at the entry of the
+// function by the address of its jump, which SetTailCallJumps gives.
+package profile
+
+import (
+	"strings"
+
+	"assembler/emulator"
+)
+
+// outsideFunctions names cycles spent before the first call, e.g. in the
+// bootstrap code or in programs without functions.
+const outsideFunctions = "(outside functions)"
+
+// Addresses of the VM registers.
+const (
+	sp  = 0
+	lcl = 1
+	arg = 2
+)
+
+// Function is a VM function of a translated program.
+type Function struct {
+	Name string
+	// File and Line are where the function is defined.
+	File string
+	Line int
+	// Entry is the ROM address of the function.
+	Entry uint16
+}
+
+type frame struct {
+	function      int
+	lcl, arg      uint16
+	callerLCL     uint16
+	returnAddress uint16
+}
+
+// returned reports whether the function of f has returned at the return
+// address.
+func (f frame) returned(ram []uint16) bool {
+	return ram[sp] == f.arg+1 || ram[lcl] == f.callerLCL
+}
+
+// Profiler counts calls and cycles per function of a running program.
+type Profiler struct {
+	functions []Function
+	entries   map[uint16]int
+	calls     []uint64
+	stack     []frame
+	// tailCallJumps has the addresses of tail call jumps, and lastPC is
+	// the address of the last executed instruction.
+	tailCallJumps map[uint16]bool
+	lastPC        uint16
+	// stackKey names functions on the stack from the outermost, separated
+	// by semicolons, and samples has cycles per stackKey.
+	stackKey string
+	samples  map[string]uint64
+}
+
+// New returns a profiler of a program with functions.
+func New(functions []Function) *Profiler {
+	p := &Profiler{
+		functions:     append([]Function{{Name: outsideFunctions}}, functions...),
+		entries:       make(map[uint16]int),
+		tailCallJumps: make(map[uint16]bool),
+		samples:       make(map[string]uint64),
+		stackKey:      outsideFunctions,
+	}
+	for i, f := range p.functions[1:] {
+		p.entries[f.Entry] = i + 1
+	}
+	p.calls = make([]uint64, len(p.functions))
+	return p
+}
+
+// SetTailCallJumps sets the ROM addresses of the jumps of tail calls, as
+// vm.CodeWriter.TailCallJumps returns them.
+func (p *Profiler) SetTailCallJumps(addrs []int) {
+	clear(p.tailCallJumps)
+	for _, addr := range addrs {
+		p.tailCallJumps[uint16(addr)] = true
+	}
+}
+
+// Run executes instructions until the program halts or maxCycles
+// instructions are executed. It reports whether the program halted.
+func (p *Profiler) Run(cpu *emulator.CPU, maxCycles uint64) bool {
+	for i := uint64(0); i < maxCycles; i++ {
+		if cpu.Halted() {
+			return true
+		}
+		p.Step(cpu)
+	}
+	return cpu.Halted()
+}
+
+// Step executes one instruction and attributes it to the functions on
+// the call stack.
+func (p *Profiler) Step(cpu *emulator.CPU) {
+	p.track(cpu)
+	p.lastPC = cpu.PC
+	cpu.Step()
+	p.samples[p.stackKey]++
+}
+
+// track updates the call stack for the instruction at PC.
+func (p *Profiler) track(cpu *emulator.CPU) {
+	pc := cpu.PC
+	if n := len(p.stack); n > 0 && p.stack[n-1].returnAddress == pc && p.stack[n-1].returned(cpu.RAM[:]) {
+		p.stack = p.stack[:n-1]
+		p.updateStackKey()
+	}
+
+	i, ok := p.entries[pc]
+	if !ok || cpu.RAM[lcl] != cpu.RAM[sp] {
+		return
+	}
+	top := frame{
+		function:      i,
+		lcl:           cpu.RAM[lcl],
+		arg:           cpu.RAM[arg],
+		callerLCL:     cpu.RAM[(cpu.RAM[lcl]-4)%emulator.RAMSize],
+		returnAddress: cpu.RAM[(cpu.RAM[lcl]-5)%emulator.RAMSize],
+	}
+	switch n := len(p.stack); {
+	case n > 0 && p.tailCallJumps[p.lastPC]:
+		p.stack[n-1] = top
+	case n > 0 && p.stack[n-1].lcl == top.lcl:
+		return // a jump to a label at the entry
+	default:
+		p.stack = append(p.stack, top)
+	}
+	p.calls[i]++
+	p.updateStackKey()
+}
+
+func (p *Profiler) updateStackKey() {
+	if len(p.stack) == 0 {
+		p.stackKey = outsideFunctions
+		return
+	}
+	names := make([]string, len(p.stack))
+	for i, f := range p.stack {
+		names[i] = p.functions[f.function].Name
+	}
+	p.stackKey = strings.Join(names, ";")
+}
@@ -0,0 +1,322 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+
+	"assembler/asm"
+	"assembler/emulator"
+	"vmtranslator/vm"
+)
+
+var profileTestFiles = map[string]string{
+	"Sys.vm": `
+function Sys.init 0
+	call Main.pair 0
+	call Main.pair 0
+	call Main.pair 0
+	call Main.forward 0
+	push constant 5
+	call Main.count 1
+label HALT
+	goto HALT
+`,
+	"Main.vm": `
+function Main.pair 0
+	call Main.leaf 0
+	call Main.leaf 0
+	add
+	return
+function Main.forward 0
+	call Main.leaf 0
+	return
+function Main.leaf 0
+	push constant 1
+	return
+function Main.count 0
+	push argument 0
+	if-goto RECURSE
+	push constant 0
+	return
+label RECURSE
+	push argument 0
+	push constant 1
+	sub
+	call Main.count 1
+	push constant 1
+	add
+	return
+`,
+}
+
+// runProfile translates and profiles sources of VM files.
+func runProfile(t *testing.T, sources map[string]string, configure func(*vm.CodeWriter)) *Profiler {
+	t.Helper()
+	files := make(map[string]io.Reader)
+	for name, src := range sources {
+		files[name] = strings.NewReader(src)
+	}
+	prog, err := vm.ParseProgram(files, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := vm.NewCodeWriter(&buf)
+	if configure != nil {
+		configure(w)
+	}
+	if err := vm.TranslateProgram(prog, w, true); err != nil {
+		t.Fatal(err)
+	}
+	program, symbols, err := asm.Assemble(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := New(Functions(prog, symbols))
+	p.SetTailCallJumps(w.TailCallJumps())
+	cpu := emulator.New(program)
+	if !p.Run(cpu, 100000) {
+		t.Fatal("program did not halt")
+	}
+	if got := p.Cycles(); got != cpu.Cycles {
+		t.Errorf("profiled %d cycles, but the program ran %d", got, cpu.Cycles)
+	}
+	return p
+}
+
+func TestProfiler(t *testing.T) {
+	for name, configure := range map[string]func(*vm.CodeWriter){
+		"plain": nil,
+		"optimized,compact": func(w *vm.CodeWriter) {
+			w.SetOptimize(true)
+			w.SetCompact(true)
+		},
+		"tos": func(w *vm.CodeWriter) {
+			w.SetCacheTop(true)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := runProfile(t, profileTestFiles, configure)
+			stats := make(map[string]Stat)
+			var self uint64
+			for _, s := range p.Stats() {
+				stats[s.Name] = s
+				self += s.Self
+			}
+			for name, want := range map[string]uint64{"Sys.init": 1, "Main.pair": 3, "Main.forward": 1, "Main.leaf": 7, "Main.count": 6} {
+				if got := stats[name].Calls; got != want {
+					t.Errorf("%s is called %d times, want %d", name, got, want)
+				}
+			}
+
+			cycles := p.Cycles()
+			if self != cycles {
+				t.Errorf("self cycles add up to %d, want %d", self, cycles)
+			}
+			if got, want := stats["Sys.init"].Total+stats[outsideFunctions].Self, cycles; got != want {
+				t.Errorf("total cycles of Sys.init and outside are %d, want %d", got, want)
+			}
+			if got, want := stats["Main.pair"].Total+stats["Main.forward"].Total, stats["Main.pair"].Self+stats["Main.forward"].Self+stats["Main.leaf"].Total; got != want {
+				t.Errorf("total cycles of callers of Main.leaf are %d, want %d", got, want)
+			}
+			if count := stats["Main.count"]; count.Total != count.Self {
+				t.Errorf("recursive Main.count has %d total cycles, want %d", count.Total, count.Self)
+			}
+		})
+	}
+}
+
+func TestProfilerTailCalls(t *testing.T) {
+	p := runProfile(t, profileTestFiles, func(w *vm.CodeWriter) {
+		w.SetTailCalls(vm.AllTailCalls)
+	})
+	// Main.leaf replaces Main.forward, which has no cycles of callees.
+	for _, s := range p.Stats() {
+		if s.Name == "Main.leaf" && s.Calls != 7 {
+			t.Errorf("Main.leaf is called %d times, want 7", s.Calls)
+		}
+		if s.Name == "Main.forward" && (s.Calls != 1 || s.Total != s.Self) {
+			t.Errorf("want Main.forward to be called once without callees, but got %+v", s)
+		}
+	}
+
+	// Self tail calls of Main.sum enter it at the same LCL, as a jump to
+	// a label at its entry would.
+	p = runProfile(t, map[string]string{"Sys.vm": selfTailCallTestSys}, func(w *vm.CodeWriter) {
+		w.SetTailCalls(vm.SelfTailCalls)
+	})
+	for _, s := range p.Stats() {
+		if s.Name == "Sys.sum" && (s.Calls != 11 || s.Total != s.Self) {
+			t.Errorf("want Sys.sum to be called 11 times without callees, but got %+v", s)
+		}
+	}
+	if len(p.stack) != 1 {
+		t.Errorf("want only Sys.init on the call stack at the end, but got %d frames", len(p.stack))
+	}
+}
+
+// selfTailCallTestSys computes 1 + ... + 10 by self recursion.
+const selfTailCallTestSys = `
+function Sys.init 0
+	push constant 10
+	push constant 0
+	call Sys.sum 2
+	pop temp 0
+label HALT
+	goto HALT
+function Sys.sum 0
+	push argument 0
+	if-goto RECURSE
+	push argument 1
+	return
+label RECURSE
+	push argument 0
+	push constant 1
+	sub
+	push argument 1
+	push argument 0
+	add
+	call Sys.sum 2
+	return
+`
+
+// recursionTestSys calls Main.f of recursionTestMain, whose deepest call
+// ends with the commands in place of %s.
+const recursionTestSys = `
+function Sys.init 0
+	push constant 3
+	call Main.f 1
+	pop temp 0
+label HALT
+	goto HALT
+`
+
+const recursionTestMain = `
+function Main.f 0
+	push argument 0
+	if-goto RECURSE
+	push constant 0
+%s
+label RECURSE
+	push argument 0
+	push constant 1
+	sub
+	call Main.f 1
+label AFTER
+	pop temp 1
+	push constant 0
+	return
+`
+
+// TestProfilerLabelAfterRecursiveCall jumps to a label at the return
+// address of the recursive call within the deepest call, which must not
+// return from it.
+func TestProfilerLabelAfterRecursiveCall(t *testing.T) {
+	deepest := "Sys.init" + strings.Repeat(";Main.f", 4)
+	deepestCycles := func(end string) uint64 {
+		p := runProfile(t, map[string]string{
+			"Sys.vm":  recursionTestSys,
+			"Main.vm": fmt.Sprintf(recursionTestMain, end),
+		}, nil)
+		if len(p.stack) != 1 {
+			t.Errorf("want only Sys.init on the call stack at the end, but got %d frames", len(p.stack))
+		}
+		return p.samples[deepest]
+	}
+	// The deepest call runs the same commands either way, and a goto more.
+	got := deepestCycles("\tgoto AFTER")
+	want := deepestCycles("\tpop temp 1\n\tpush constant 0\n\treturn")
+	if got < want {
+		t.Errorf("the deepest call has %d cycles, want at least %d", got, want)
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	var buf bytes.Buffer
+	if err := runProfile(t, profileTestFiles, nil).WriteReport(&buf); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 8 {
+		t.Fatalf("want a header, 6 functions and a total, but got\n%s", buf.String())
+	}
+	if fields := strings.Fields(lines[1]); fields[len(fields)-1] != "Main.count" {
+		t.Errorf("want Main.count to spend the most cycles, but got %s", lines[1])
+	}
+}
+
+func TestWritePprof(t *testing.T) {
+	p := runProfile(t, profileTestFiles, nil)
+	var buf bytes.Buffer
+	if err := p.WritePprof(&buf); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var strs []string
+	var cycles uint64
+	samples := 0
+	protoFields(t, data, func(field int, value []byte) {
+		switch field {
+		case profileSample:
+			samples++
+			protoFields(t, value, func(field int, values []byte) {
+				if field == sampleValue {
+					v, _ := binary.Uvarint(values)
+					cycles += v
+				}
+			})
+		case profileStringTable:
+			strs = append(strs, string(value))
+		}
+	})
+	if cycles != p.Cycles() {
+		t.Errorf("samples have %d cycles, want %d", cycles, p.Cycles())
+	}
+	if samples != len(p.samples) {
+		t.Errorf("got %d samples, want %d", samples, len(p.samples))
+	}
+	for _, want := range []string{"", "cycles", "Main.pair", "Main.leaf", "Main.count", "Main.vm"} {
+		if !slices.Contains(strs, want) {
+			t.Errorf("string table %q has no %q", strs, want)
+		}
+	}
+	if strs[0] != "" {
+		t.Errorf("want the first string to be empty, but got %q", strs[0])
+	}
+}
+
+// protoFields calls f with length-delimited fields of a protocol buffer
+// message, and skips varint fields.
+func protoFields(t *testing.T, data []byte, f func(field int, value []byte)) {
+	t.Helper()
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		data = data[n:]
+		switch key & 7 {
+		case wireVarint:
+			_, n = binary.Uvarint(data)
+			data = data[n:]
+		case wireBytes:
+			size, n := binary.Uvarint(data)
+			value := data[n : n+int(size)]
+			data = data[n+int(size):]
+			f(int(key>>3), value)
+		default:
+			t.Fatalf("unexpected wire type %d", key&7)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package profile
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"assembler/asm"
+	"vmtranslator/vm"
+)
+
+// Functions returns the functions of prog at their addresses in symbols,
+// which are the symbols of the translated program.
+func Functions(prog *vm.Program, symbols *asm.SymbolTable) []Function {
+	var funcs []Function
+	for _, file := range prog.Files {
+		for _, cmd := range file.Commands {
+			if cmd.Type != vm.C_FUNCTION {
+				continue
+			}
+			if entry, ok := symbols.GetAddress(cmd.Arg1); ok {
+				funcs = append(funcs, Function{Name: cmd.Arg1, File: file.Name, Line: cmd.Line, Entry: entry})
+			}
+		}
+	}
+	return funcs
+}
+
+// Stat is the profile of a function.
+type Stat struct {
+	Name  string
+	Calls uint64
+	// Self is the number of cycles spent in the function itself, and
+	// Total includes the cycles of the functions it calls.
+	Self, Total uint64
+}
+
+// Cycles returns the number of profiled cycles.
+func (p *Profiler) Cycles() uint64 {
+	var total uint64
+	for _, cycles := range p.samples {
+		total += cycles
+	}
+	return total
+}
+
+// Stats returns the functions which are called or run, most Self cycles
+// first.
+func (p *Profiler) Stats() []Stat {
+	stats := make(map[string]*Stat)
+	stat := func(name string) *Stat {
+		s, ok := stats[name]
+		if !ok {
+			s = &Stat{Name: name}
+			stats[name] = s
+		}
+		return s
+	}
+	for key, cycles := range p.samples {
+		names := strings.Split(key, ";")
+		for i, name := range names {
+			if !slices.Contains(names[:i], name) { // recursion
+				stat(name).Total += cycles
+			}
+		}
+		stat(names[len(names)-1]).Self += cycles
+	}
+	for i, calls := range p.calls {
+		if calls > 0 {
+			stat(p.functions[i].Name).Calls = calls
+		}
+	}
+
+	var sorted []Stat
+	for _, s := range stats {
+		sorted = append(sorted, *s)
+	}
+	slices.SortFunc(sorted, func(a, b Stat) int {
+		if c := cmp.Compare(b.Self, a.Self); c != 0 {
+			return c
+		}
+		if c := cmp.Compare(b.Total, a.Total); c != 0 {
+			return c
+		}
+		return cmp.Compare(a.Name, b.Name)
+	})
+	return sorted
+}
+
+// WriteReport writes calls and cycles per function, most self cycles first.
+func (p *Profiler) WriteReport(out io.Writer) error {
+	total := p.Cycles()
+	percent := func(cycles uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(cycles) / float64(total)
+	}
+	if _, err := fmt.Fprintf(out, "%10s %12s %7s %12s %7s  %s\n", "calls", "self", "self%", "total", "total%", "function"); err != nil {
+		return err
+	}
+	for _, s := range p.Stats() {
+		_, err := fmt.Fprintf(out, "%10d %12d %6.2f%% %12d %6.2f%%  %s\n", s.Calls, s.Self, percent(s.Self), s.Total, percent(s.Total), s.Name)
+		if err != nil {
+			return err
+		}
+	}
+	_, err := fmt.Fprintf(out, "total: %d cycles\n", total)
+	return err
+}
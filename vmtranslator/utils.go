@@ -9,9 +9,3 @@ func Die(format string, args ...any) {
 	fmt.Fprintf(os.Stderr, format+"\n", args...)
 	os.Exit(1)
 }
-
-func Assert(condition bool) {
-	if !condition {
-		panic("assertion failed")
-	}
-}
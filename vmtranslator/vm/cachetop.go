@@ -0,0 +1,176 @@
+package vm
+
+// This file implements the code generation enabled by SetCacheTop, which
+// keeps the top of the stack in D within basic blocks. While topInD is true,
+// the stack consists of the values in RAM below SP and then D, so a push
+// followed by a command consuming it never goes through RAM.
+//
+// The top is spilled to RAM before labels, jumps, calls and returns, and
+// before commands without a cached template, so that control flow always
+// joins with the whole stack in RAM.
+
+// SetCacheTop makes WriteCommands keep the top of the stack in D between
+// commands of a basic block.
+func (w *CodeWriter) SetCacheTop(cacheTop bool) {
+	w.cacheTop = cacheTop
+}
+
+var cachedOperators = map[string]string{
+	"add": "D=D+M",
+	"sub": "D=M-D",
+	"and": "D=D&M",
+	"or":  "D=D|M",
+	"neg": "D=-D",
+	"not": "D=!D",
+}
+
+// writeCached writes leading commands with the top of the stack in D.
+// It returns the number of written commands, or 0 after spilling the top
+// when they have no cached template.
+func (w *CodeWriter) writeCached(cmds []Command) int {
+	at := func(i int) Command {
+		if i < len(cmds) {
+			return cmds[i]
+		}
+		return Command{Type: -1}
+	}
+	first, second, third := at(0), at(1), at(2)
+
+	switch {
+	case first.Type == C_PUSH && !w.checksPointer(first.Arg1):
+		w.writeComment(first)
+		w.spillTop()
+		w.writeLoad(first.Arg1, first.Arg2)
+		w.topInD = true
+	case first.Type == C_POP && !w.checksPointer(first.Arg1):
+		w.writeComment(first)
+		w.writeCachedPop(first.Arg1, first.Arg2)
+	case isCompare(first) && isArithmetic(second, "not") && third.Type == C_IF:
+		w.writeComment(first, second, third)
+		w.writeCachedCompareJump(first.Arg1, true, third.Arg1)
+		return 3
+	case isCompare(first) && second.Type == C_IF:
+		w.writeComment(first, second)
+		w.writeCachedCompareJump(first.Arg1, false, second.Arg1)
+		return 2
+	case isCompare(first) && !w.compact:
+		w.writeComment(first)
+		w.writeCachedCompare(first.Arg1)
+	case first.Type == C_ARITHMETIC && cachedOperators[first.Arg1] != "":
+		w.writeComment(first)
+		w.fillTop()
+		if first.Arg1 != "neg" && first.Arg1 != "not" {
+			w.writef("@SP") // pop x
+			w.writef("AM=M-1")
+		}
+		w.writef("%s", cachedOperators[first.Arg1])
+	case first.Type == C_IF:
+		w.writeComment(first)
+		w.fillTop()
+		w.topInD = false
+		w.writef("@%s", w.qualifyLabel(first.Arg1))
+		w.writef("D;JNE")
+	default:
+		w.spillTop()
+		return 0
+	}
+	w.writef("")
+	return 1
+}
+
+// spillTop pushes the top of the stack to RAM if it is in D.
+func (w *CodeWriter) spillTop() {
+	if !w.topInD {
+		return
+	}
+	w.topInD = false
+	w.writePushCheck()
+	w.writef("@SP") // spill
+	w.writef("AM=M+1")
+	w.writef("A=A-1")
+	w.writef("M=D")
+}
+
+// fillTop pops the top of the stack to D unless it is there already.
+func (w *CodeWriter) fillTop() {
+	if w.topInD {
+		return
+	}
+	w.topInD = true
+	w.writef("@SP") // fill
+	w.writef("AM=M-1")
+	w.writef("D=M")
+}
+
+func (w *CodeWriter) writeCachedPop(segment string, index int) {
+	if segment == "constant" {
+		if w.topInD {
+			w.topInD = false
+			return
+		}
+		w.writef("@SP")
+		w.writef("M=M-1")
+		return
+	}
+	w.fillTop()
+	w.topInD = false
+	base, isBased := segmentBaseSymbols[segment]
+	if isBased && index > maxChainedIndex {
+		w.writef("@R13")
+		w.writef("M=D")
+		w.writeAddress(base, index)
+		w.writef("@R14")
+		w.writef("M=D")
+		w.writef("@R13")
+		w.writef("D=M")
+		w.writef("@R14")
+		w.writef("A=M")
+		w.writef("M=D")
+		return
+	}
+	w.writeStore(segment, index)
+}
+
+// writeCachedCompare replaces x and y with the boolean `x cmp y` in D.
+func (w *CodeWriter) writeCachedCompare(cmp string) {
+	setTrueLabel := w.genSequencialLabel("SET_TRUE")
+	endLabel := w.genSequencialLabel("END_COMPARE")
+
+	w.fillTop()
+	w.writeCachedDifference(cmp)
+	w.writef("@%s", setTrueLabel)
+	w.writef("D;%s", compareJumps[cmp])
+	w.writef("D=0")
+	w.writef("@%s", endLabel)
+	w.writef("0;JMP")
+	w.writef("(%s)", setTrueLabel)
+	w.writef("D=-1")
+	w.writef("(%s)", endLabel)
+}
+
+// writeCachedCompareJump pops x and y and jumps to label if `x cmp y`
+// holds, or if it doesn't when negate is true.
+func (w *CodeWriter) writeCachedCompareJump(cmp string, negate bool, label string) {
+	jump := compareJumps[cmp]
+	if negate {
+		jump = negatedJumps[jump]
+	}
+	w.fillTop()
+	w.topInD = false
+	w.writeCachedDifference(cmp)
+	w.writef("@%s", w.qualifyLabel(label))
+	w.writef("D;%s", jump)
+}
+
+// writeCachedDifference pops x and sets D to x - y, where D is y, or to a
+// value with its sign with SetExactCompare.
+func (w *CodeWriter) writeCachedDifference(cmp string) {
+	w.writef("@SP") // pop x
+	if w.isExactCompare(cmp) {
+		w.writef("M=M-1")
+		w.writeExactDifference(w.pointAtSP)
+		return
+	}
+	w.writef("AM=M-1")
+	w.writef("D=M-D") // D = x - y
+}
@@ -0,0 +1,73 @@
+package vm
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func cachedTop(w *CodeWriter) {
+	w.SetCacheTop(true)
+}
+
+func optimizedAndCachedTop(w *CodeWriter) {
+	w.SetOptimize(true)
+	w.SetCacheTop(true)
+}
+
+func TestCachedTopBehavesAsPlainCode(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{"Sys.vm": optimizerTestSys})
+	plain := runHack(t, translateFiles(t, nil, paths...), 100000)
+
+	for name, configure := range map[string]func(*CodeWriter){
+		"tos":           cachedTop,
+		"optimized,tos": optimizedAndCachedTop,
+		"compact,tos": func(w *CodeWriter) {
+			compacted(w)
+			cachedTop(w)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			cached := runHack(t, translateFiles(t, configure, paths...), 100000)
+			compareRAM(t, plain, cached)
+		})
+	}
+}
+
+// haltLoop stops programs of project 07 falling off the end of their code.
+const haltLoop = `
+(TEST_HALT)
+@TEST_HALT
+0;JMP
+`
+
+// TestCachedTopIsSmallerAndFaster compares instructions and cycles until
+// the programs of projects 07 and 08 halt.
+func TestCachedTopIsSmallerAndFaster(t *testing.T) {
+	for _, dir := range projectTestDirs {
+		name := filepath.Base(dir)
+		if name == "SimpleFunction" {
+			continue // returns to wherever its test script points
+		}
+		t.Run(name, func(t *testing.T) {
+			paths := vmFilesIn(t, dir)
+			tstPath := filepath.Join(dir, name+".tst")
+			run := func(configure func(*CodeWriter)) (size int, cycles uint64) {
+				src := translateFiles(t, configure, paths...) + haltLoop
+				cpu, maxCycles := loadTst(t, src, tstPath)
+				if !cpu.Run(maxCycles) {
+					t.Fatalf("program did not halt in %d cycles", maxCycles)
+				}
+				return romSize(t, src), cpu.Cycles
+			}
+			plainSize, plainCycles := run(nil)
+			cachedSize, cachedCycles := run(cachedTop)
+			t.Logf("plain %d words, %d cycles; tos %d words, %d cycles", plainSize, plainCycles, cachedSize, cachedCycles)
+			if cachedSize >= plainSize {
+				t.Errorf("want fewer instructions, but got %d -> %d words", plainSize, cachedSize)
+			}
+			if cachedCycles >= plainCycles {
+				t.Errorf("want fewer cycles, but got %d -> %d", plainCycles, cachedCycles)
+			}
+		})
+	}
+}
@@ -0,0 +1,107 @@
+package vm
+
+// This file implements the runtime checks enabled by SetChecked and
+// SetCheckedPointers. A failed check jumps to a trap, which stores a marker
+// in TrapMarkerAddress and halts in an infinite loop.
+
+const (
+	stackOverflowTrap = "$$stack_overflow"
+	pointerFaultTrap  = "$$pointer_fault"
+
+	// stackLimit is the end of the stack, where the heap starts.
+	stackLimit = 2048
+	// pointerMin and pointerMax are the addresses this and that segments
+	// can access: the heap and the memory maps of the screen and keyboard.
+	pointerMin = 2048
+	pointerMax = 24576
+)
+
+const (
+	// TrapMarkerAddress is where traps store their marker.
+	TrapMarkerAddress = 15
+	// StackOverflowMarker means SP went beyond the stack.
+	StackOverflowMarker = 0xDEAD
+	// PointerFaultMarker means this or that accessed outside the heap
+	// and the memory maps.
+	PointerFaultMarker = 0xBAD0
+)
+
+// SetChecked makes pushes, calls and functions trap when the stack grows
+// into the heap at 2048.
+func (w *CodeWriter) SetChecked(checked bool) {
+	w.checked = checked
+}
+
+// SetCheckedPointers makes accesses of this and that segments trap when
+// they are outside the heap and the memory maps.
+func (w *CodeWriter) SetCheckedPointers(checked bool) {
+	w.checkPointers = checked
+}
+
+func (w *CodeWriter) checksPointer(segment string) bool {
+	return w.checkPointers && (segment == "this" || segment == "that")
+}
+
+// writeStackCheck traps if pushing reserve more values would go beyond
+// the stack. It overwrites D.
+func (w *CodeWriter) writeStackCheck(reserve int) {
+	if !w.checked {
+		return
+	}
+	w.writef("@SP")
+	w.writef("D=M")
+	w.writef("@%d", stackLimit-reserve)
+	w.writef("D=D-A")
+	w.writef("@%s", stackOverflowTrap)
+	w.writef("D;JGT")
+}
+
+// writePushCheck traps if pushing D would go beyond the stack. It keeps D
+// in R13 while checking.
+func (w *CodeWriter) writePushCheck() {
+	if !w.checked {
+		return
+	}
+	w.writef("@R13")
+	w.writef("M=D")
+	w.writeStackCheck(1)
+	w.writef("@R13")
+	w.writef("D=M")
+}
+
+// writePointerCheck traps unless D is an address this and that segments
+// can access. It keeps D.
+func (w *CodeWriter) writePointerCheck() {
+	w.writef("@%d", pointerMin)
+	w.writef("D=D-A")
+	w.writef("@%s", pointerFaultTrap)
+	w.writef("D;JLT")
+	w.writef("@%d", pointerMax-pointerMin)
+	w.writef("D=D-A")
+	w.writef("@%s", pointerFaultTrap)
+	w.writef("D;JGT")
+	w.writef("@%d", pointerMax)
+	w.writef("D=D+A")
+}
+
+func (w *CodeWriter) writeTraps() {
+	if w.checked {
+		w.writeTrap(stackOverflowTrap, StackOverflowMarker)
+	}
+	if w.checkPointers {
+		w.writeTrap(pointerFaultTrap, PointerFaultMarker)
+	}
+}
+
+func (w *CodeWriter) writeTrap(trap string, marker uint16) {
+	w.writef("// %s: store 0x%X to RAM[%d] and halt", trap, marker, TrapMarkerAddress)
+	w.writef("(%s)", trap)
+	w.writef("@%d", ^marker) // markers don't fit into A-commands
+	w.writef("D=!A")
+	w.writef("@%d", TrapMarkerAddress)
+	w.writef("M=D")
+	w.writef("(%s.halt)", trap)
+	w.writef("@%s.halt", trap)
+	w.writef("0;JMP")
+	w.writef("")
+}
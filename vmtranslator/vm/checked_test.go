@@ -0,0 +1,112 @@
+package vm
+
+import (
+	"testing"
+
+	"assembler/emulator"
+)
+
+// heapSentinel is stored where the heap starts to find pushes beyond the
+// stack.
+const heapSentinel = 0x1234
+
+func checked(w *CodeWriter) {
+	w.SetChecked(true)
+	w.SetCheckedPointers(true)
+}
+
+func TestChecked(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		marker uint16
+	}{
+		{"infinite recursion", `
+function Sys.init 0
+push constant 0
+call Sys.init 1
+return
+`, StackOverflowMarker},
+		{"deep locals", `
+function Sys.init 0
+label LOOP
+call Sys.f 0
+goto LOOP
+function Sys.f 1000
+push constant 1
+call Sys.f 0
+return
+`, StackOverflowMarker},
+		{"pushes", `
+function Sys.init 0
+label LOOP
+push constant 1
+push local 0
+goto LOOP
+`, StackOverflowMarker},
+		{"that out of heap", `
+function Sys.init 0
+push constant 100
+pop pointer 1
+push that 0
+label END
+goto END
+`, PointerFaultMarker},
+		{"this out of heap", `
+function Sys.init 0
+push constant 24577
+pop pointer 0
+push constant 1
+pop this 0
+label END
+goto END
+`, PointerFaultMarker},
+		{"no fault", `
+function Sys.init 0
+push constant 24576
+pop pointer 1
+push that 0
+push constant 2048
+pop pointer 0
+pop this 0
+push constant 1
+pop temp 0
+label END
+goto END
+`, 0},
+	}
+	modes := map[string]func(*CodeWriter){
+		"plain":     nil,
+		"optimized": optimized,
+		"compact":   compacted,
+		"tos":       cachedTop,
+	}
+	for _, tt := range tests {
+		paths := writeVMFiles(t, map[string]string{"Sys.vm": tt.src})
+		for mode, configure := range modes {
+			t.Run(tt.name+"/"+mode, func(t *testing.T) {
+				src := translateFiles(t, func(w *CodeWriter) {
+					if configure != nil {
+						configure(w)
+					}
+					checked(w)
+				}, paths...)
+				cpu := emulator.New(assemble(t, src))
+				cpu.RAM[stackLimit] = heapSentinel
+				cpu.Run(1_000_000)
+				got := cpu.RAM[TrapMarkerAddress]
+				if tt.marker == 0 && (got == StackOverflowMarker || got == PointerFaultMarker) {
+					t.Errorf("RAM[%d] = 0x%X, want no trap", TrapMarkerAddress, got)
+				} else if tt.marker != 0 && got != tt.marker {
+					t.Errorf("RAM[%d] = 0x%X, want 0x%X", TrapMarkerAddress, got, tt.marker)
+				}
+				if sp := cpu.RAM[0]; sp > stackLimit {
+					t.Errorf("SP = %d went beyond the stack", sp)
+				}
+				if got := cpu.RAM[stackLimit]; tt.marker == StackOverflowMarker && got != heapSentinel {
+					t.Errorf("RAM[%d] = 0x%X, want 0x%X unchanged by pushes", stackLimit, got, heapSentinel)
+				}
+			})
+		}
+	}
+}
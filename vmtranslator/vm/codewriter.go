@@ -1,8 +1,9 @@
-package main
+package vm
 
 import (
 	"fmt"
 	"io"
+	"strings"
 )
 
 type sequenceGenerator map[string]int
@@ -18,22 +19,132 @@ type CodeWriter struct {
 	seqGen          sequenceGenerator
 	currentFile     string
 	currentFunction string
+	currentLine     int
+	err             error
+	optimize        bool
+	compact         bool
+	extended        bool
+	checked         bool
+	checkPointers   bool
+	cacheTop        bool
+	exactCompare    bool
+	tailCalls       TailCallMode
+	topInD          bool
+	sizes           []CodeSize
+	locations       []SourceLocation
+	tailCallJumps   []int
+	sizeIndex       map[CodeSize]int
 }
 
 func NewCodeWriter(out io.Writer) *CodeWriter {
 	return &CodeWriter{
-		out:    out,
-		seqGen: make(sequenceGenerator),
+		out:       out,
+		seqGen:    make(sequenceGenerator),
+		sizeIndex: make(map[CodeSize]int),
 	}
 }
 
 func (w *CodeWriter) SetFilename(filename string) {
 	w.currentFile = filename
+	w.currentFunction = ""
+}
+
+// SetOptimize enables shorter code templates and fusion of common
+// command sequences. See optimizer.go.
+func (w *CodeWriter) SetOptimize(optimize bool) {
+	w.optimize = optimize
+}
+
+// SetCompact makes comparisons, calls and returns jump to shared routines
+// written by WriteInit instead of inlining them. See compact.go.
+func (w *CodeWriter) SetCompact(compact bool) {
+	w.compact = compact
+}
+
+// SetExtended makes WriteInit write the routines of extended commands.
+// See extended.go.
+func (w *CodeWriter) SetExtended(extended bool) {
+	w.extended = extended
+}
+
+// WriteCommands writes commands in order.
+func (w *CodeWriter) WriteCommands(commands []Command) {
+	for len(commands) > 0 {
+		n := 0
+		w.currentLine = commands[0].Line
+		if w.cacheTop {
+			n = w.writeCached(commands)
+		}
+		if n == 0 && w.tailCalls != NoTailCalls {
+			n = w.writeTailCall(commands)
+		}
+		if n == 0 && w.optimize {
+			n = w.writeOptimized(commands)
+		}
+		if n == 0 {
+			w.WriteCommand(commands[0])
+			n = 1
+		}
+		commands = commands[n:]
+	}
+	w.spillTop()
+}
+
+// WriteCommand writes a single command with the whole stack in RAM.
+func (w *CodeWriter) WriteCommand(cmd Command) {
+	w.spillTop()
+	w.currentLine = cmd.Line
+	switch cmd.Type {
+	case C_ARITHMETIC:
+		w.WriteArithmetic(cmd.Arg1)
+	case C_PUSH, C_POP:
+		w.WritePushPop(cmd.Type, cmd.Arg1, cmd.Arg2)
+	case C_LABEL:
+		w.WriteLabel(cmd.Arg1)
+	case C_GOTO:
+		w.WriteGoto(cmd.Arg1)
+	case C_IF:
+		w.WriteIf(cmd.Arg1)
+	case C_CALL:
+		w.WriteCall(cmd.Arg1, cmd.Arg2)
+	case C_FUNCTION:
+		w.WriteFunction(cmd.Arg1, cmd.Arg2)
+	case C_RETURN:
+		w.WriteReturn()
+	}
 }
 
 func (w *CodeWriter) writef(format string, args ...any) {
-	fmt.Fprintf(w.out, format, args...)
-	io.WriteString(w.out, "\n")
+	line := fmt.Sprintf(format, args...)
+	if isInstruction(line) {
+		w.countInstruction()
+		w.recordLocation()
+	}
+	if _, err := io.WriteString(w.out, line+"\n"); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Err returns the first error found while writing, which is an *Error
+// for invalid commands.
+func (w *CodeWriter) Err() error {
+	return w.err
+}
+
+// fail records an error in the current command.
+func (w *CodeWriter) fail(format string, args ...any) {
+	if w.err == nil {
+		w.err = &Error{File: w.currentFile, Line: w.currentLine, Message: fmt.Sprintf(format, args...)}
+	}
+}
+
+// isInstruction reports whether an assembly line is an A- or C-command.
+func isInstruction(line string) bool {
+	if i := strings.Index(line, "//"); i >= 0 {
+		line = line[:i]
+	}
+	line = strings.TrimSpace(line)
+	return line != "" && !strings.HasPrefix(line, "(")
 }
 
 func (w *CodeWriter) genSequencialLabel(prefix string) string {
@@ -44,6 +155,15 @@ func (w *CodeWriter) genSequencialLabel(prefix string) string {
 func (w *CodeWriter) WriteArithmetic(command string) {
 	w.writef("// %s", command)
 
+	if _, ok := compareJumps[command]; ok && w.compact {
+		w.writeCompareCall(command)
+		return
+	}
+	if extendedCommands[command] {
+		w.writeExtended(command)
+		return
+	}
+
 	// Comments assume following initial state.
 	//  stack
 	// +-----+
@@ -71,72 +191,8 @@ func (w *CodeWriter) WriteArithmetic(command string) {
 		w.writef("A=M-1") // point y
 		w.writef("M=-M")  // y = -y
 
-	case "eq":
-		w.writef("@SP") // pop y
-		w.writef("AM=M-1")
-		w.writef("D=M")
-		w.writef("A=A-1") // point x
-		w.writef("D=M-D") // D = x - y
-
-		endSetTrueLabel := w.genSequencialLabel("END_SET_TRUE")
-
-		// set false
-		w.writef("@SP")
-		w.writef("A=M-1") // point x
-		w.writef("M=0")   // x = false
-		w.writef("@%s", endSetTrueLabel)
-		w.writef("D;JNE")
-
-		// set true
-		w.writef("@SP")
-		w.writef("A=M-1") // point x
-		w.writef("M=-1")  // x = true
-		w.writef("(%s)", endSetTrueLabel)
-
-	case "gt":
-		w.writef("@SP") // pop y
-		w.writef("AM=M-1")
-		w.writef("D=M")
-		w.writef("A=A-1") // point x
-		w.writef("D=M-D") // D = x - y
-
-		endSetTrueLabel := w.genSequencialLabel("END_SET_TRUE")
-
-		// set false
-		w.writef("@SP")
-		w.writef("A=M-1") // point x
-		w.writef("M=0")   // x = false
-		w.writef("@%s", endSetTrueLabel)
-		w.writef("D;JLE")
-
-		// set true
-		w.writef("@SP")
-		w.writef("A=M-1") // point x
-		w.writef("M=-1")  // x = true
-		w.writef("(%s)", endSetTrueLabel)
-
-	case "lt":
-		w.writef("@SP") // pop y
-		w.writef("AM=M-1")
-		w.writef("D=M")
-		w.writef("A=A-1") // point x
-		w.writef("D=M-D") // D = x - y
-
-		endSetTrueLabel := w.genSequencialLabel("END_SET_TRUE")
-
-		// set false
-		w.writef("@SP")
-		w.writef("A=M-1") // point x
-		w.writef("M=0")   // x = false
-		w.writef("@%s", endSetTrueLabel)
-		w.writef("D;JGE")
-
-		// set true
-		w.writef("@SP")
-		w.writef("A=M-1") // point x
-		w.writef("M=-1")  // x = true
-		w.writef("(%s)", endSetTrueLabel)
-
+	case "eq", "gt", "lt":
+		w.writeCompareInline(command)
 	case "and":
 		w.writef("@SP") // pop y
 		w.writef("AM=M-1")
@@ -163,6 +219,12 @@ func (w *CodeWriter) WriteArithmetic(command string) {
 func (w *CodeWriter) writePush(segment string, index int) {
 	w.writef("// push %s %d", segment, index)
 
+	if w.optimize && !w.checksPointer(segment) {
+		w.writePushOptimized(segment, index)
+		w.writef("")
+		return
+	}
+
 	switch segment {
 	case "argument":
 		w.writef("@ARG")
@@ -177,22 +239,22 @@ func (w *CodeWriter) writePush(segment string, index int) {
 		w.writef("A=D+A")
 		w.writef("D=M")
 	case "static":
-		w.writef("@%s.static_%d", w.currentFile, index)
+		w.writef("@%s", w.staticSymbol(index))
 		w.writef("D=M")
 	case "constant":
 		w.writef("@%d", index)
 		w.writef("D=A")
-	case "this":
-		w.writef("@THIS")
+	case "this", "that":
+		w.writef("@%s", segmentBaseSymbols[segment])
 		w.writef("D=M")
 		w.writef("@%d", index)
-		w.writef("A=D+A")
-		w.writef("D=M")
-	case "that":
-		w.writef("@THAT")
-		w.writef("D=M")
-		w.writef("@%d", index)
-		w.writef("A=D+A")
+		if w.checksPointer(segment) {
+			w.writef("D=D+A")
+			w.writePointerCheck()
+			w.writef("A=D")
+		} else {
+			w.writef("A=D+A")
+		}
 		w.writef("D=M")
 	case "pointer":
 		if index == 0 {
@@ -200,18 +262,18 @@ func (w *CodeWriter) writePush(segment string, index int) {
 		} else if index == 1 {
 			w.writef("@THAT")
 		} else {
-			Die("Segmentation Fault: access over pointer segment: index=%d", index)
+			w.fail("index %d is out of pointer segment (0-1)", index)
 		}
 		w.writef("D=M")
 	case "temp":
 		// temp segment is R5 ~ R12
 		if index < 0 || 7 < index {
-			Die("Segmentation Fault: access over temp segment: index=%d", index)
+			w.fail("index %d is out of temp segment (0-7)", index)
 		}
 		w.writef("@R%d", index+5)
 		w.writef("D=M")
 	default:
-		Die("Unknown segment: %s", segment)
+		w.fail("unknown segment %s", segment)
 	}
 
 	w.writePushD()
@@ -219,8 +281,50 @@ func (w *CodeWriter) writePush(segment string, index int) {
 	w.writef("")
 }
 
+// writeCompareInline replaces x and y with the boolean `x cmp y`.
+func (w *CodeWriter) writeCompareInline(cmp string) {
+	w.writef("@SP") // pop y
+	w.writef("AM=M-1")
+	w.writef("D=M")
+	if w.isExactCompare(cmp) {
+		w.writeExactDifference(w.pointBelowTop)
+	} else {
+		w.writef("A=A-1") // point x
+		w.writef("D=M-D") // D = x - y
+	}
+
+	endSetTrueLabel := w.genSequencialLabel("END_SET_TRUE")
+
+	// set false
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=0")   // x = false
+	w.writef("@%s", endSetTrueLabel)
+	w.writef("D;%s", negatedJumps[compareJumps[cmp]])
+
+	// set true
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=-1")  // x = true
+	w.writef("(%s)", endSetTrueLabel)
+}
+
 // writePushD writes asm which means push D-Register.
 func (w *CodeWriter) writePushD() {
+	w.writePushCheck()
+	w.writePushDUnchecked()
+}
+
+// writePushDUnchecked pushes D without checking the stack, which is done
+// beforehand by writeStackCheck.
+func (w *CodeWriter) writePushDUnchecked() {
+	if w.optimize {
+		w.writef("@SP")
+		w.writef("AM=M+1")
+		w.writef("A=A-1")
+		w.writef("M=D")
+		return
+	}
 	// M[SP] = D
 	w.writef("@SP")
 	w.writef("A=M")
@@ -233,6 +337,12 @@ func (w *CodeWriter) writePushD() {
 func (w *CodeWriter) writePop(segment string, index int) {
 	w.writef("// pop %s %d", segment, index)
 
+	if w.optimize && !w.checksPointer(segment) {
+		w.writePopOptimized(segment, index)
+		w.writef("")
+		return
+	}
+
 	// SP--
 	w.writef("@SP")
 	w.writef("AM=M-1")
@@ -256,42 +366,40 @@ func (w *CodeWriter) writePop(segment string, index int) {
 		w.writef("@%d", index)
 		w.writef("D=D+A")
 	case "static":
-		w.writef("@%s.static_%d", w.currentFile, index)
+		w.writef("@%s", w.staticSymbol(index))
 		w.writef("D=A")
 	case "constant":
 		// We cannot save poped value into constant segment.
 		// So we discard it when typ is C_POP.
 		goto END
-	case "this":
-		w.writef("@THIS")
-		w.writef("D=M")
-		w.writef("@%d", index)
-		w.writef("D=D+A")
-	case "that":
-		w.writef("@THAT")
+	case "this", "that":
+		w.writef("@%s", segmentBaseSymbols[segment])
 		w.writef("D=M")
 		w.writef("@%d", index)
 		w.writef("D=D+A")
+		if w.checksPointer(segment) {
+			w.writePointerCheck()
+		}
 	case "pointer":
 		if index == 0 {
 			w.writef("@THIS")
 		} else if index == 1 {
 			w.writef("@THAT")
 		} else {
-			Die("Segmentation Fault: access over pointer segment: index=%d", index)
+			w.fail("index %d is out of pointer segment (0-1)", index)
 		}
 		w.writef("M=D")
 		goto END
 	case "temp":
 		// temp segment is R5 ~ R12
 		if index < 0 || 7 < index {
-			Die("Segmentation Fault: access over temp segment: index=%d", index)
+			w.fail("index %d is out of temp segment (0-7)", index)
 		}
 		w.writef("@R%d", index+5)
 		w.writef("M=D")
 		goto END
 	default:
-		Die("Unknown segment: %s", segment)
+		w.fail("unknown segment %s", segment)
 	}
 
 	// D holds destination address at this point.
@@ -316,10 +424,14 @@ func (w *CodeWriter) WritePushPop(typ CommandType, segment string, index int) {
 	case C_POP:
 		w.writePop(segment, index)
 	default:
-		Die("Invalid command type for WritePushPop: %v", typ)
+		w.fail("invalid command type %v for WritePushPop", typ)
 	}
 }
 
+func (w *CodeWriter) staticSymbol(index int) string {
+	return fmt.Sprintf("%s.static_%d", w.currentFile, index)
+}
+
 // qualifyLabel makes label identified in asm file.
 func (w *CodeWriter) qualifyLabel(label string) string {
 	return fmt.Sprintf("%s.%s$%s", w.currentFile, w.currentFunction, label)
@@ -354,17 +466,24 @@ func (w *CodeWriter) WriteIf(label string) {
 func (w *CodeWriter) WriteCall(funcName string, nArgs int) {
 	w.writef("// call %s %d", funcName, nArgs)
 
+	if w.compact {
+		w.writeCallCall(funcName, nArgs)
+		return
+	}
+
+	w.writeStackCheck(5)
+
 	// Push return address
 	returnAddressLabel := w.genSequencialLabel("RETURN_ADDR")
 	w.writef("@%s", returnAddressLabel)
 	w.writef("D=A")
-	w.writePushD()
+	w.writePushDUnchecked()
 
 	// Push LCL, ARG, THIS, THAT
 	for _, label := range []string{"LCL", "ARG", "THIS", "THAT"} {
 		w.writef("@%s", label)
 		w.writef("D=M")
-		w.writePushD()
+		w.writePushDUnchecked()
 	}
 
 	// Set ARG to SP - nArgs - 5(return-address, LCL, ARG, THIS, THAT)
@@ -398,9 +517,10 @@ func (w *CodeWriter) WriteFunction(funcName string, nLocals int) {
 	w.writef("(%s) // {", funcName)
 
 	// Initialize local variables
+	w.writeStackCheck(nLocals)
 	w.writef("D=0")
 	for i := 0; i < nLocals; i++ {
-		w.writePushD()
+		w.writePushDUnchecked()
 	}
 
 	w.writef("")
@@ -409,6 +529,18 @@ func (w *CodeWriter) WriteFunction(funcName string, nLocals int) {
 func (w *CodeWriter) WriteReturn() {
 	w.writef("// return (from %s)", w.currentFunction)
 
+	if w.compact {
+		w.writef("@%s", returnRoutine)
+		w.writef("0;JMP")
+	} else {
+		w.writeReturnBody()
+	}
+
+	w.writef("// }")
+	w.writef("")
+}
+
+func (w *CodeWriter) writeReturnBody() {
 	// Use R15 for saving return address.
 	// We need to get return address first because
 	// when nargs == 0, return address will be lost
@@ -443,9 +575,6 @@ func (w *CodeWriter) WriteReturn() {
 	w.writef("@R15")
 	w.writef("A=M")
 	w.writef("0;JMP")
-
-	w.writef("// }")
-	w.writef("")
 }
 
 func (w *CodeWriter) WriteInit() {
@@ -458,4 +587,19 @@ func (w *CodeWriter) WriteInit() {
 	// Jump to Sys.init
 	w.currentFunction = "Sys.init"
 	w.WriteCall("Sys.init", 0)
+
+	w.writeRoutines()
+}
+
+// WritePreamble writes what programs without bootstrap code need before
+// the first command, that is, shared routines and traps jumped over.
+func (w *CodeWriter) WritePreamble() {
+	if !w.hasRoutines() {
+		return
+	}
+	w.writef("@%s", programStartLabel)
+	w.writef("0;JMP")
+	w.writeRoutines()
+	w.writef("(%s)", programStartLabel)
+	w.writef("")
 }
@@ -0,0 +1,165 @@
+package vm
+
+import "strings"
+
+// This file implements the shared routines enabled by SetCompact.
+// Call sites jump to a routine with the address to come back in R14,
+// trading a few cycles for much smaller code.
+
+const (
+	compareRoutine = "$$compare"
+	callRoutine    = "$$call"
+	returnRoutine  = "$$return"
+
+	programStartLabel = "$$start"
+)
+
+// writeCompareCall replaces x and y with the boolean `x cmp y`
+// by the $$compare routine.
+func (w *CodeWriter) writeCompareCall(cmp string) {
+	w.writeRoutineCall(compareRoutine + "." + cmp)
+}
+
+// writeRoutineCall jumps to routine with the address to come back in R14.
+func (w *CodeWriter) writeRoutineCall(routine string) {
+	returnAddressLabel := w.genSequencialLabel("RETURN_ADDR")
+	w.writef("@%s", returnAddressLabel)
+	w.writef("D=A")
+	w.writef("@R14")
+	w.writef("M=D")
+	w.writef("@%s", routine)
+	w.writef("0;JMP")
+	w.writef("(%s)", returnAddressLabel)
+	w.writef("")
+}
+
+// writeCallCall calls funcName by the $$call routine, which takes
+// the function address in R13, the return address in R14 and nArgs in D.
+func (w *CodeWriter) writeCallCall(funcName string, nArgs int) {
+	returnAddressLabel := w.genSequencialLabel("RETURN_ADDR")
+	w.writef("@%s", funcName)
+	w.writef("D=A")
+	w.writef("@R13")
+	w.writef("M=D")
+	w.writef("@%s", returnAddressLabel)
+	w.writef("D=A")
+	w.writef("@R14")
+	w.writef("M=D")
+	w.writef("@%d", nArgs)
+	w.writef("D=A")
+	w.writef("@%s", callRoutine)
+	w.writef("0;JMP")
+	w.writef("(%s) // back from %s to %s", returnAddressLabel, funcName, w.currentFunction)
+	w.writef("")
+}
+
+func (w *CodeWriter) hasRoutines() bool {
+	return w.compact || w.extended || w.checked || w.checkPointers
+}
+
+// writeRoutines writes the shared routines and traps enabled.
+func (w *CodeWriter) writeRoutines() {
+	if w.compact {
+		w.writeCompareRoutine()
+		w.writeCallRoutine()
+		w.writeReturnRoutine()
+	}
+	if w.extended {
+		w.writeExtendedRoutines()
+	}
+	w.writeTraps()
+}
+
+func (w *CodeWriter) writeCompareRoutine() {
+	trueLabel := compareRoutine + ".true"
+	falseLabel := compareRoutine + ".false"
+
+	cmps := []string{"eq", "gt", "lt"}
+	if w.extended {
+		cmps = append(cmps, "le", "ge", "ne")
+	}
+	w.writef("// %s: replace x and y with x %s y and go back to R14", compareRoutine, strings.Join(cmps, "/"))
+	for _, cmp := range cmps {
+		w.writef("(%s.%s)", compareRoutine, cmp)
+		w.writef("@SP") // pop y
+		w.writef("AM=M-1")
+		w.writef("D=M")
+		if w.isExactCompare(cmp) {
+			w.writeExactDifference(w.pointBelowTop)
+		} else {
+			w.writef("A=A-1") // point x
+			w.writef("D=M-D") // D = x - y
+		}
+		w.writef("@%s", trueLabel)
+		w.writef("D;%s", compareJumps[cmp])
+		w.writef("@%s", falseLabel)
+		w.writef("0;JMP")
+	}
+	w.writef("(%s)", falseLabel)
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=0")   // x = false
+	w.writef("@R14")
+	w.writef("A=M")
+	w.writef("0;JMP")
+	w.writef("(%s)", trueLabel)
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=-1")  // x = true
+	w.writef("@R14")
+	w.writef("A=M")
+	w.writef("0;JMP")
+	w.writef("")
+}
+
+func (w *CodeWriter) writeCallRoutine() {
+	w.writef("// %s: call the function at R13 with D arguments and return to R14", callRoutine)
+	w.writef("(%s)", callRoutine)
+
+	// R15 = nArgs + 5(return-address, LCL, ARG, THIS, THAT)
+	w.writef("@5")
+	w.writef("D=D+A")
+	w.writef("@R15")
+	w.writef("M=D")
+
+	w.writeStackCheck(5)
+
+	// Push return address
+	w.writef("@R14")
+	w.writef("D=M")
+	w.writePushDUnchecked()
+
+	// Push LCL, ARG, THIS, THAT
+	for _, label := range []string{"LCL", "ARG", "THIS", "THAT"} {
+		w.writef("@%s", label)
+		w.writef("D=M")
+		w.writePushDUnchecked()
+	}
+
+	// Set ARG to SP - nArgs - 5
+	w.writef("@R15")
+	w.writef("D=M")
+	w.writef("@SP")
+	w.writef("D=M-D")
+	w.writef("@ARG")
+	w.writef("M=D")
+
+	// Set LCL to SP
+	w.writef("@SP")
+	w.writef("D=M")
+	w.writef("@LCL")
+	w.writef("M=D")
+
+	// Goto function
+	w.writef("@R13")
+	w.writef("A=M")
+	w.writef("0;JMP")
+	w.writef("")
+}
+
+func (w *CodeWriter) writeReturnRoutine() {
+	w.writef("// %s: return from the current function", returnRoutine)
+	w.writef("(%s)", returnRoutine)
+	w.writeReturnBody()
+	w.writef("")
+}
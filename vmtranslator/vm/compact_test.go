@@ -0,0 +1,59 @@
+package vm
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func compacted(w *CodeWriter) {
+	w.SetCompact(true)
+}
+
+func optimizedAndCompacted(w *CodeWriter) {
+	w.SetOptimize(true)
+	w.SetCompact(true)
+}
+
+func TestCompactCodeBehavesAsPlainCode(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{"Sys.vm": optimizerTestSys})
+	plain := runHack(t, translateFiles(t, nil, paths...), 100000)
+
+	for name, configure := range map[string]func(*CodeWriter){
+		"compact":   compacted,
+		"optimized": optimizedAndCompacted,
+	} {
+		t.Run(name, func(t *testing.T) {
+			compact := runHack(t, translateFiles(t, configure, paths...), 100000)
+			compareRAM(t, plain, compact)
+		})
+	}
+}
+
+func TestCompactCodePassesProjectTests(t *testing.T) {
+	for _, dir := range []string{
+		"../../projects/08/FunctionCalls/FibonacciElement",
+		"../../projects/08/FunctionCalls/StaticsTest",
+		"../../projects/08/FunctionCalls/NestedCall",
+	} {
+		name := filepath.Base(dir)
+		t.Run(name, func(t *testing.T) {
+			paths := vmFilesIn(t, dir)
+			cpu := runTst(t, translateFiles(t, compacted, paths...), filepath.Join(dir, name+".tst"))
+			checkCmp(t, cpu, filepath.Join(dir, name+".cmp"))
+		})
+	}
+}
+
+func TestCompactCodeIsSmaller(t *testing.T) {
+	paths := vmFilesIn(t, "../../projects/09/hilow")
+	plain := romSize(t, translateFiles(t, nil, paths...))
+	compact := romSize(t, translateFiles(t, compacted, paths...))
+	both := romSize(t, translateFiles(t, optimizedAndCompacted, paths...))
+	t.Logf("ROM size: plain %d, compact %d, optimized and compact %d words", plain, compact, both)
+	if compact > plain*3/4 {
+		t.Errorf("want at least 25%% reduction, but got %d -> %d words", plain, compact)
+	}
+	if both > 32768 {
+		t.Errorf("want optimized and compact code to fit in ROM32K, but got %d words", both)
+	}
+}
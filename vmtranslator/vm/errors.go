@@ -0,0 +1,36 @@
+package vm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Error is a problem found in a VM program. Line is 0 for problems of
+// a whole file, and File is empty for problems of the whole program.
+type Error struct {
+	File    string
+	Line    int
+	Message string
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.File == "":
+		return e.Message
+	case e.Line == 0:
+		return fmt.Sprintf("%s: %s", e.File, e.Message)
+	default:
+		return fmt.Sprintf("%s:%d: %s", e.File, e.Line, e.Message)
+	}
+}
+
+// ErrorList is a list of errors sorted by file and line.
+type ErrorList []*Error
+
+func (l ErrorList) Error() string {
+	var ss []string
+	for _, e := range l {
+		ss = append(ss, e.Error())
+	}
+	return strings.Join(ss, "\n")
+}
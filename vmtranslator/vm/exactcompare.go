@@ -0,0 +1,75 @@
+package vm
+
+// This file implements the comparisons enabled by SetExactCompare. Code
+// templates compare x and y by the sign of x - y, which is wrong when the
+// subtraction overflows, e.g. 32767 > -1 is false and -32768 < 1 is false.
+// Exact comparisons subtract only operands of the same sign, which can't
+// overflow, and otherwise decide by the sign of x.
+//
+// eq and ne are exact either way, since x - y wraps around to 0 only if
+// x == y.
+
+// SetExactCompare makes gt, lt, le and ge correct for all operands at the
+// cost of larger and slower code.
+func (w *CodeWriter) SetExactCompare(exact bool) {
+	w.exactCompare = exact
+}
+
+// isExactCompare reports whether cmp is written with writeExactDifference.
+func (w *CodeWriter) isExactCompare(cmp string) bool {
+	return w.exactCompare && cmp != "eq" && cmp != "ne"
+}
+
+// writeExactDifference sets D to a value which has the sign of x - y
+// without overflow, where D is y and pointX writes instructions setting A
+// to the address of x. It overwrites R13.
+func (w *CodeWriter) writeExactDifference(pointX func()) {
+	xNegativeLabel := w.genSequencialLabel("X_NEGATIVE")
+	sameSignLabel := w.genSequencialLabel("SAME_SIGN")
+	endLabel := w.genSequencialLabel("END_DIFFERENCE")
+
+	w.writef("@R13")
+	w.writef("M=D") // R13 = y
+	pointX()
+	w.writef("D=M") // D = x
+	w.writef("@%s", xNegativeLabel)
+	w.writef("D;JLT")
+
+	// x >= 0
+	w.writef("@R13")
+	w.writef("D=M") // D = y
+	w.writef("@%s", sameSignLabel)
+	w.writef("D;JGE")
+	w.writef("D=1") // x >= 0 > y
+	w.writef("@%s", endLabel)
+	w.writef("0;JMP")
+
+	// x < 0
+	w.writef("(%s)", xNegativeLabel)
+	w.writef("@R13")
+	w.writef("D=M") // D = y
+	w.writef("@%s", sameSignLabel)
+	w.writef("D;JLT")
+	w.writef("D=-1") // x < 0 <= y
+	w.writef("@%s", endLabel)
+	w.writef("0;JMP")
+
+	w.writef("(%s)", sameSignLabel)
+	pointX()
+	w.writef("D=M-D") // D = x - y
+	w.writef("(%s)", endLabel)
+}
+
+// pointBelowTop writes instructions setting A to the address of the
+// value below the top of the stack.
+func (w *CodeWriter) pointBelowTop() {
+	w.writef("@SP")
+	w.writef("A=M-1")
+}
+
+// pointAtSP writes instructions setting A to SP, where the last popped
+// value is.
+func (w *CodeWriter) pointAtSP() {
+	w.writef("@SP")
+	w.writef("A=M")
+}
@@ -0,0 +1,104 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// compareBoundaries are operands at which x - y overflows.
+var compareBoundaries = []int16{-32768, -1, 0, 1, 32767}
+
+var comparisons = []struct {
+	command string
+	want    func(x, y int16) bool
+}{
+	{"eq", func(x, y int16) bool { return x == y }},
+	{"gt", func(x, y int16) bool { return x > y }},
+	{"lt", func(x, y int16) bool { return x < y }},
+	{"le", func(x, y int16) bool { return x <= y }},
+	{"ge", func(x, y int16) bool { return x >= y }},
+	{"ne", func(x, y int16) bool { return x != y }},
+}
+
+// checkComparisons checks results of extendedTestProgram for command over
+// compareBoundaries, which ram returns by index.
+func checkComparisons(t *testing.T, command string, want func(x, y int16) bool, branch bool, ram func(i int) int16) {
+	t.Helper()
+	i := 0
+	for _, x := range compareBoundaries {
+		for _, y := range compareBoundaries {
+			want := boolValue(want(x, y))
+			if branch {
+				want = -want
+			}
+			if got := ram(i); got != want {
+				t.Errorf("%d %d %s = %d, want %d", x, y, command, got, want)
+			}
+			i++
+		}
+	}
+}
+
+func TestExactCompare(t *testing.T) {
+	modes := map[string]Options{
+		"plain":                 {},
+		"optimized":             {Optimize: true},
+		"compact":               {Compact: true},
+		"optimized,compact":     {Optimize: true, Compact: true},
+		"tos":                   {CacheTop: true},
+		"optimized,tos":         {Optimize: true, CacheTop: true},
+		"optimized,compact,tos": {Optimize: true, Compact: true, CacheTop: true},
+	}
+	for _, tt := range comparisons {
+		for _, branch := range []bool{false, true} {
+			src := extendedTestProgram(tt.command, compareBoundaries, compareBoundaries, branch)
+			for mode, opts := range modes {
+				tt, opts := tt, opts
+				t.Run(fmt.Sprintf("%s/branch=%t/%s", tt.command, branch, mode), func(t *testing.T) {
+					opts.Extended = true
+					opts.ExactCompare = true
+					var buf bytes.Buffer
+					if err := Translate(map[string]io.Reader{"Sys.vm": strings.NewReader(src)}, &buf, opts); err != nil {
+						t.Fatal(err)
+					}
+					cpu := runHack(t, buf.String(), 1_000_000)
+					checkComparisons(t, tt.command, tt.want, branch, func(i int) int16 {
+						return int16(cpu.RAM[resultBase+i])
+					})
+				})
+			}
+		}
+	}
+}
+
+func TestCompareOverflowsWithoutExactCompare(t *testing.T) {
+	src := extendedTestProgram("gt", []int16{32767}, []int16{-1}, false)
+	var buf bytes.Buffer
+	if err := Translate(map[string]io.Reader{"Sys.vm": strings.NewReader(src)}, &buf, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := runHack(t, buf.String(), 10000).RAM[resultBase]; got != 0 {
+		t.Errorf("32767 > -1 = %d, want 0 from x - y overflowing", int16(got))
+	}
+}
+
+func TestGoWriterExactCompare(t *testing.T) {
+	for _, tt := range comparisons {
+		for _, branch := range []bool{false, true} {
+			src := extendedTestProgram(tt.command, compareBoundaries, compareBoundaries, branch)
+			paths := writeVMFiles(t, map[string]string{"Sys.vm": src})
+			n := len(compareBoundaries) * len(compareBoundaries)
+			ram := runGo(t, paths, Options{Extended: true, ExactCompare: true}, fmt.Sprintf("%d-%d", resultBase, resultBase+n-1))
+			checkComparisons(t, tt.command, tt.want, branch, func(i int) int16 {
+				v, ok := ram[resultBase+i]
+				if !ok {
+					t.Fatalf("RAM[%d] not printed", resultBase+i)
+				}
+				return v
+			})
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package vm
+
+// This file implements the extended commands enabled by SetExtended.
+// Comparisons, dup and swap are written inline. Multiplication, division
+// and shifts jump to shared routines written by WriteInit, which replace
+// x and y with the result and go back to R14. They keep their state in
+// variables named after them, e.g. $$mul.x.
+
+const (
+	mulRoutine = "$$mul"
+	divRoutine = "$$div"
+	shlRoutine = "$$shl"
+	shrRoutine = "$$shr"
+)
+
+var extendedRoutines = map[string]string{
+	"mul": mulRoutine,
+	"div": divRoutine,
+	"shl": shlRoutine,
+	"shr": shrRoutine,
+}
+
+func (w *CodeWriter) writeExtended(command string) {
+	if routine, ok := extendedRoutines[command]; ok {
+		w.writeRoutineCall(routine)
+		return
+	}
+
+	switch command {
+	case "le", "ge", "ne":
+		w.writeCompareInline(command)
+	case "dup":
+		w.writef("@SP")
+		w.writef("A=M-1")
+		w.writef("D=M")
+		w.writePushD()
+	case "swap":
+		// x, y = x + y - (x + y - y), x + y - y
+		w.writef("@SP")
+		w.writef("A=M-1") // point y
+		w.writef("D=M")
+		w.writef("A=A-1") // point x
+		w.writef("M=D+M") // x = x + y
+		w.writef("D=M-D") // D = x
+		w.writef("A=A+1")
+		w.writef("M=D") // y = x
+		w.writef("A=A-1")
+		w.writef("M=M-D") // x = y
+	}
+	w.writef("")
+}
+
+func (w *CodeWriter) writeExtendedRoutines() {
+	w.writeMulRoutine()
+	w.writeDivRoutine()
+	w.writeShlRoutine()
+	w.writeShrRoutine()
+}
+
+// writePopOperands pops y into routine.y and sets D to x.
+func (w *CodeWriter) writePopOperands(routine string) {
+	w.writef("@SP") // pop y
+	w.writef("AM=M-1")
+	w.writef("D=M")
+	w.writef("@%s.y", routine)
+	w.writef("M=D")
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("D=M")
+}
+
+// writeRoutineReturn replaces x with D and goes back to R14.
+func (w *CodeWriter) writeRoutineReturn() {
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=D")
+	w.writef("@R14")
+	w.writef("A=M")
+	w.writef("0;JMP")
+}
+
+// writeMulRoutine adds x shifted left for each bit set in y.
+func (w *CodeWriter) writeMulRoutine() {
+	r := mulRoutine
+	w.writef("// %s: replace x and y with x * y and go back to R14", r)
+	w.writef("(%s)", r)
+	w.writePopOperands(r)
+	w.writef("@%s.x", r)
+	w.writef("M=D")
+	w.writef("@%s.product", r)
+	w.writef("M=0")
+	w.writef("@%s.bit", r)
+	w.writef("M=1")
+	w.writef("(%s.loop)", r)
+	w.writef("@%s.y", r)
+	w.writef("D=M")
+	w.writef("@%s.end", r) // until no bits are left in y
+	w.writef("D;JEQ")
+	w.writef("@%s.bit", r)
+	w.writef("D=D&M")
+	w.writef("@%s.next", r)
+	w.writef("D;JEQ")
+	w.writef("@%s.y", r)
+	w.writef("M=M-D") // clear the bit
+	w.writef("@%s.x", r)
+	w.writef("D=M")
+	w.writef("@%s.product", r)
+	w.writef("M=D+M")
+	w.writef("(%s.next)", r)
+	w.writef("@%s.x", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.bit", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.loop", r)
+	w.writef("0;JMP")
+	w.writef("(%s.end)", r)
+	w.writef("@%s.product", r)
+	w.writef("D=M")
+	w.writeRoutineReturn()
+	w.writef("")
+}
+
+// writeDivRoutine divides absolute values by restoring division, shifting
+// in bits of x from the most significant one, and negates the quotient if
+// the signs differ.
+func (w *CodeWriter) writeDivRoutine() {
+	r := divRoutine
+	w.writef("// %s: replace x and y with x / y and go back to R14", r)
+	w.writef("(%s)", r)
+	w.writePopOperands(r)
+	w.writef("@%s.x", r)
+	w.writef("M=D")
+	w.writef("@%s.negative", r)
+	w.writef("M=0")
+	w.writef("@%s.quotient", r)
+	w.writef("M=0")
+	w.writef("@%s.remainder", r)
+	w.writef("M=0")
+	w.writef("@16")
+	w.writef("D=A")
+	w.writef("@%s.count", r)
+	w.writef("M=D")
+
+	// x = |x|, y = |y|
+	for _, operand := range []string{"x", "y"} {
+		w.writef("@%s.%s", r, operand)
+		w.writef("D=M")
+		w.writef("@%s.%s_positive", r, operand)
+		w.writef("D;JGE")
+		w.writef("@%s.%s", r, operand)
+		w.writef("M=-D")
+		w.writef("@%s.negative", r)
+		w.writef("M=!M")
+		w.writef("(%s.%s_positive)", r, operand)
+	}
+	w.writef("@%s.y", r)
+	w.writef("D=M")
+	w.writef("@%s.end", r) // x / 0 is 0
+	w.writef("D;JEQ")
+	w.writef("@%s.min", r) // y is -32768
+	w.writef("D;JLT")
+
+	w.writef("(%s.loop)", r)
+	// remainder = remainder * 2 + the most significant bit of x
+	w.writef("@%s.remainder", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.x", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.shifted", r)
+	w.writef("D;JGE")
+	w.writef("@%s.remainder", r)
+	w.writef("M=M+1")
+	w.writef("(%s.shifted)", r)
+	w.writef("@%s.quotient", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	// remainder >= y, where remainder may exceed 32767
+	w.writef("@%s.remainder", r)
+	w.writef("D=M")
+	w.writef("@%s.subtract", r)
+	w.writef("D;JLT")
+	w.writef("@%s.y", r)
+	w.writef("D=D-M")
+	w.writef("@%s.next", r)
+	w.writef("D;JLT")
+	w.writef("(%s.subtract)", r)
+	w.writef("@%s.y", r)
+	w.writef("D=M")
+	w.writef("@%s.remainder", r)
+	w.writef("M=M-D")
+	w.writef("@%s.quotient", r)
+	w.writef("M=M+1")
+	w.writef("(%s.next)", r)
+	w.writef("@%s.count", r)
+	w.writef("MD=M-1")
+	w.writef("@%s.loop", r)
+	w.writef("D;JGT")
+
+	w.writef("@%s.negative", r)
+	w.writef("D=M")
+	w.writef("@%s.end", r)
+	w.writef("D;JEQ")
+	w.writef("@%s.quotient", r)
+	w.writef("M=-M")
+	w.writef("(%s.end)", r)
+	w.writef("@%s.quotient", r)
+	w.writef("D=M")
+	w.writeRoutineReturn()
+
+	// x / -32768 is 1 for x = -32768, and 0 otherwise.
+	w.writef("(%s.min)", r)
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("D=M")
+	w.writef("@32767")
+	w.writef("D=D+A")
+	w.writef("D=D+1") // D = x + 32768
+	w.writef("@%s.quotient", r)
+	w.writef("M=0")
+	w.writef("@%s.end", r)
+	w.writef("D;JNE")
+	w.writef("@%s.quotient", r)
+	w.writef("M=1")
+	w.writef("@%s.end", r)
+	w.writef("0;JMP")
+	w.writef("")
+}
+
+// writeShlRoutine doubles x y times.
+func (w *CodeWriter) writeShlRoutine() {
+	r := shlRoutine
+	w.writef("// %s: replace x and y with x << y and go back to R14", r)
+	w.writef("(%s)", r)
+	w.writePopOperands(r)
+	w.writef("@%s.y", r)
+	w.writef("D=M")
+	w.writef("@15")
+	w.writef("D=D-A")
+	w.writef("@%s.zero", r)
+	w.writef("D;JGT")
+	w.writef("(%s.loop)", r)
+	w.writef("@%s.y", r)
+	w.writef("MD=M-1")
+	w.writef("@%s.end", r)
+	w.writef("D;JLT")
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.loop", r)
+	w.writef("0;JMP")
+	w.writef("(%s.zero)", r)
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=0")
+	w.writef("(%s.end)", r)
+	w.writef("@R14")
+	w.writef("A=M")
+	w.writef("0;JMP")
+	w.writef("")
+}
+
+// writeShrRoutine shifts 16 - y most significant bits of x into
+// the sign of x.
+func (w *CodeWriter) writeShrRoutine() {
+	r := shrRoutine
+	w.writef("// %s: replace x and y with x >> y and go back to R14", r)
+	w.writef("(%s)", r)
+	w.writePopOperands(r)
+	w.writef("@%s.x", r)
+	w.writef("M=D")
+	w.writef("@%s.result", r)
+	w.writef("M=0")
+	w.writef("@%s.positive", r)
+	w.writef("D;JGE")
+	w.writef("@%s.result", r)
+	w.writef("M=-1")
+	w.writef("(%s.positive)", r)
+	// count = 16 - y, at most 16
+	w.writef("@%s.y", r)
+	w.writef("D=M")
+	w.writef("@16")
+	w.writef("D=A-D")
+	w.writef("@%s.count", r)
+	w.writef("M=D")
+	w.writef("@16")
+	w.writef("D=D-A")
+	w.writef("@%s.loop", r)
+	w.writef("D;JLE")
+	w.writef("@16")
+	w.writef("D=A")
+	w.writef("@%s.count", r)
+	w.writef("M=D")
+	w.writef("(%s.loop)", r)
+	w.writef("@%s.count", r)
+	w.writef("MD=M-1")
+	w.writef("@%s.end", r)
+	w.writef("D;JLT")
+	w.writef("@%s.result", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.x", r)
+	w.writef("D=M")
+	w.writef("M=D+M")
+	w.writef("@%s.loop", r)
+	w.writef("D;JGE")
+	w.writef("@%s.result", r)
+	w.writef("M=M+1")
+	w.writef("@%s.loop", r)
+	w.writef("0;JMP")
+	w.writef("(%s.end)", r)
+	w.writef("@%s.result", r)
+	w.writef("D=M")
+	w.writeRoutineReturn()
+	w.writef("")
+}
@@ -0,0 +1,137 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+)
+
+// resultBase is where extendedTestProgram stores results.
+const resultBase = 3000
+
+// pushValue writes VM commands pushing v.
+func pushValue(b *strings.Builder, v int16) {
+	switch {
+	case v == -32768:
+		b.WriteString("push constant 32767\nneg\npush constant 1\nsub\n")
+	case v < 0:
+		fmt.Fprintf(b, "push constant %d\nneg\n", -v)
+	default:
+		fmt.Fprintf(b, "push constant %d\n", v)
+	}
+}
+
+// extendedTestProgram applies command to each pair of xs and ys, and stores
+// the results from resultBase. If branch is true, the result of command is
+// stored through if-goto as 1 or 0.
+func extendedTestProgram(command string, xs, ys []int16, branch bool) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "function Sys.init 0\npush constant %d\npop pointer 1\n", resultBase)
+	i := 0
+	for _, x := range xs {
+		for _, y := range ys {
+			pushValue(&b, x)
+			pushValue(&b, y)
+			fmt.Fprintf(&b, "%s\n", command)
+			if branch {
+				fmt.Fprintf(&b, "if-goto TRUE%d\npush constant 0\ngoto STORE%d\n", i, i)
+				fmt.Fprintf(&b, "label TRUE%d\npush constant 1\nlabel STORE%d\n", i, i)
+			}
+			fmt.Fprintf(&b, "pop that %d\n", i)
+			i++
+		}
+	}
+	b.WriteString("label END\ngoto END\n")
+	return b.String()
+}
+
+func boolValue(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+func TestExtendedCommands(t *testing.T) {
+	values := []int16{-32768, -32767, -300, -7, -2, -1, 0, 1, 2, 3, 7, 300, 16384, 32767}
+	shifts := []int16{-1, 0, 1, 2, 7, 15, 16, 17}
+	small := []int16{-300, -7, -1, 0, 1, 7, 300}
+
+	tests := []struct {
+		command string
+		xs, ys  []int16
+		want    func(x, y int16) int16
+	}{
+		{"mul", values, values, func(x, y int16) int16 { return x * y }},
+		{"div", values, values, func(x, y int16) int16 {
+			if y == 0 {
+				return 0
+			}
+			return x / y
+		}},
+		{"shl", values, shifts, func(x, y int16) int16 {
+			if y < 0 {
+				return x
+			}
+			return x << y
+		}},
+		{"shr", values, shifts, func(x, y int16) int16 {
+			if y < 0 {
+				return x
+			}
+			return x >> y
+		}},
+		{"le", small, small, func(x, y int16) int16 { return boolValue(x <= y) }},
+		{"ge", small, small, func(x, y int16) int16 { return boolValue(x >= y) }},
+		{"ne", small, small, func(x, y int16) int16 { return boolValue(x != y) }},
+		{"swap", small, small, func(x, y int16) int16 { return x }},
+		{"dup", small, small, func(x, y int16) int16 { return y }},
+	}
+	modes := map[string]Options{
+		"plain":             {},
+		"optimized":         {Optimize: true},
+		"compact":           {Compact: true},
+		"optimized,compact": {Optimize: true, Compact: true},
+	}
+	for _, tt := range tests {
+		for _, branch := range []bool{false, true} {
+			if branch && tt.command != "le" && tt.command != "ge" && tt.command != "ne" {
+				continue
+			}
+			src := extendedTestProgram(tt.command, tt.xs, tt.ys, branch)
+			for mode, opts := range modes {
+				t.Run(fmt.Sprintf("%s/branch=%t/%s", tt.command, branch, mode), func(t *testing.T) {
+					opts.Extended = true
+					var buf bytes.Buffer
+					if err := Translate(map[string]io.Reader{"Sys.vm": strings.NewReader(src)}, &buf, opts); err != nil {
+						t.Fatal(err)
+					}
+					cpu := runHack(t, buf.String(), 10_000_000)
+					i := 0
+					for _, x := range tt.xs {
+						for _, y := range tt.ys {
+							want := tt.want(x, y)
+							if branch {
+								want = -want
+							}
+							if got := int16(cpu.RAM[resultBase+i]); got != want {
+								t.Errorf("%d %d %s = %d, want %d", x, y, tt.command, got, want)
+							}
+							i++
+						}
+					}
+				})
+			}
+		}
+	}
+}
+
+func TestExtendedCommandsNeedExtended(t *testing.T) {
+	files := map[string]io.Reader{"Main.vm": strings.NewReader("push constant 1\ndup\nmul\n")}
+	err := Translate(files, io.Discard, Options{})
+	if err == nil || !strings.Contains(err.Error(), "Main.vm:2: unknown command dup") {
+		t.Errorf("want unknown command dup, got %v", err)
+	}
+}
@@ -0,0 +1,476 @@
+package vm
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// GoWriter translates VM programs into standalone Go programs, which run
+// much faster than Hack code on an emulator. The generated program keeps
+// the stack, the segments and the frames of calls in a simulated 16-bit
+// RAM with the same layout as Hack code, but a VM function becomes a Go
+// function, and call and return become a Go call and return.
+//
+// Statics are allocated from RAM[16] in order of appearance as the
+// assembler does for Hack code. Comparisons subtract as Hack code does,
+// so they overflow alike. Return addresses in frames are 0.
+//
+// Without bootstrap, the program runs code outside functions, or the first
+// function if it starts with one. It ends when it falls off the end of it,
+// loops in `label L; goto L`, or calls Sys.halt, which the OS defines as
+// an infinite loop. It takes arguments ADDR=VALUE to set RAM before it
+// runs and ADDR or FIRST-LAST to print RAM when it ends. See goRuntime.
+type GoWriter struct {
+	out          io.Writer
+	err          error
+	statics      map[string]int
+	funcNames    map[string]string
+	labels       map[string]string
+	labelCount   int
+	currentFile  string
+	exactCompare bool
+}
+
+const goStaticBase = 16
+
+// goRegisters are the RAM addresses of segment bases in generated code.
+var goRegisters = map[string]string{
+	"argument": "arg",
+	"local":    "lcl",
+	"this":     "this",
+	"that":     "that",
+}
+
+// goOperators are the runtime functions of arithmetic commands.
+var goOperators = map[string]string{
+	"add":  "opAdd",
+	"sub":  "opSub",
+	"neg":  "opNeg",
+	"eq":   "opEq",
+	"gt":   "opGt",
+	"lt":   "opLt",
+	"and":  "opAnd",
+	"or":   "opOr",
+	"not":  "opNot",
+	"mul":  "opMul",
+	"div":  "opDiv",
+	"shl":  "opShl",
+	"shr":  "opShr",
+	"le":   "opLe",
+	"ge":   "opGe",
+	"ne":   "opNe",
+	"dup":  "opDup",
+	"swap": "opSwap",
+}
+
+// haltFunction ends programs when called.
+const haltFunction = "Sys.halt"
+
+func NewGoWriter(out io.Writer) *GoWriter {
+	return &GoWriter{
+		out:       out,
+		statics:   make(map[string]int),
+		funcNames: make(map[string]string),
+	}
+}
+
+// SetExactCompare makes comparisons correct for all operands instead of
+// testing the sign of x - y as Hack code does. See CodeWriter.SetExactCompare.
+func (w *GoWriter) SetExactCompare(exact bool) {
+	w.exactCompare = exact
+}
+
+// WriteProgram writes prog as a Go program, which calls Sys.init if
+// bootstrap is true and otherwise runs code outside functions.
+func (w *GoWriter) WriteProgram(prog *Program, bootstrap bool) error {
+	var names []string
+	for _, file := range prog.Files {
+		names = append(names, file.Name)
+		for _, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION {
+				w.funcNames[cmd.Arg1] = fmt.Sprintf("f%d_%s", len(w.funcNames), goIdentifier(cmd.Arg1))
+			}
+		}
+	}
+
+	w.writef("// Code generated by vmtranslator from %s. DO NOT EDIT.", strings.Join(names, ", "))
+	w.writef("")
+	w.writef("%s", strings.TrimLeft(goRuntime, "\n"))
+	w.writef("const exactCompare = %t", w.exactCompare)
+	w.writef("")
+	w.writef("func run() {")
+	if bootstrap {
+		w.writef("\tram[sp] = 256")
+		w.writef("\tcall(%s, 0)", w.funcNames[entryFunction])
+	} else {
+		w.writeOutsideFunctions(prog)
+	}
+	w.writef("}")
+
+	for _, file := range prog.Files {
+		w.currentFile = file.Name
+		for i, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION {
+				w.writeFunction(file.Commands[i:])
+			}
+		}
+	}
+	return w.err
+}
+
+func (w *GoWriter) writef(format string, args ...any) {
+	if _, err := fmt.Fprintf(w.out, format+"\n", args...); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// fail records an error in the current command.
+func (w *GoWriter) fail(cmd Command, format string, args ...any) {
+	if w.err == nil {
+		w.err = &Error{File: w.currentFile, Line: cmd.Line, Message: fmt.Sprintf(format, args...)}
+	}
+}
+
+// goIdentifier replaces characters of VM names which Go identifiers
+// cannot have.
+func goIdentifier(name string) string {
+	return strings.Map(func(r rune) rune {
+		if 'a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// writeOutsideFunctions writes commands before the first function of each
+// file in the body of run. A program starting with a function runs it
+// without a call, as Hack code does.
+func (w *GoWriter) writeOutsideFunctions(prog *Program) {
+	if len(prog.Files) > 0 && len(prog.Files[0].Commands) > 0 {
+		if first := prog.Files[0].Commands[0]; first.Type == C_FUNCTION {
+			w.writef("\t%s()", w.funcNames[first.Arg1])
+			return
+		}
+	}
+	for _, file := range prog.Files {
+		w.currentFile = file.Name
+		end := len(file.Commands)
+		for i, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION {
+				end = i
+				break
+			}
+		}
+		w.writeBody(file.Commands[:end])
+	}
+}
+
+// writeFunction writes the function at cmds[0] up to the next function.
+func (w *GoWriter) writeFunction(cmds []Command) {
+	end := len(cmds)
+	for i, cmd := range cmds[1:] {
+		if cmd.Type == C_FUNCTION {
+			end = i + 1
+			break
+		}
+	}
+	w.writef("")
+	w.writef("// %s", cmds[0])
+	w.writef("func %s() {", w.funcNames[cmds[0].Arg1])
+	if cmds[0].Arg2 > 0 {
+		w.writef("\tpushLocals(%d)", cmds[0].Arg2)
+	}
+	w.writeBody(cmds[1:end])
+	w.writef("}")
+}
+
+// writeBody writes commands of a Go function. Labels are written only if
+// jumped to, since Go rejects unused labels.
+func (w *GoWriter) writeBody(cmds []Command) {
+	w.labels = make(map[string]string)
+	used := make(map[string]bool)
+	for _, cmd := range cmds {
+		switch cmd.Type {
+		case C_LABEL:
+			w.labels[cmd.Arg1] = fmt.Sprintf("l%d_%s", w.labelCount, goIdentifier(cmd.Arg1))
+			w.labelCount++
+		case C_GOTO, C_IF:
+			used[cmd.Arg1] = true
+		}
+	}
+
+	for i, cmd := range cmds {
+		switch cmd.Type {
+		case C_LABEL:
+			if used[cmd.Arg1] {
+				w.writef("%s:", w.labels[cmd.Arg1])
+			}
+			if i+1 < len(cmds) && cmds[i+1].Type == C_GOTO && cmds[i+1].Arg1 == cmd.Arg1 {
+				w.writef("\thalt() // %s; goto %s", cmd, cmd.Arg1)
+			}
+		default:
+			w.writeCommand(cmd)
+		}
+	}
+}
+
+func (w *GoWriter) writeCommand(cmd Command) {
+	switch cmd.Type {
+	case C_ARITHMETIC:
+		w.writef("\t%s()", goOperators[cmd.Arg1])
+	case C_PUSH:
+		if cmd.Arg1 == "constant" {
+			w.writef("\tpush(%d)", cmd.Arg2)
+		} else {
+			w.writef("\tpush(%s)", w.segment(cmd))
+		}
+	case C_POP:
+		if cmd.Arg1 == "constant" {
+			w.writef("\tpop()")
+		} else {
+			w.writef("\t%s = pop()", w.segment(cmd))
+		}
+	case C_GOTO:
+		w.writef("\tjump()")
+		w.writef("\tgoto %s", w.label(cmd))
+	case C_IF:
+		w.writef("\tif pop() != 0 {")
+		w.writef("\t\tjump()")
+		w.writef("\t\tgoto %s", w.label(cmd))
+		w.writef("\t}")
+	case C_CALL:
+		if cmd.Arg1 == haltFunction {
+			w.writef("\thalt() // %s", cmd)
+			return
+		}
+		name, ok := w.funcNames[cmd.Arg1]
+		if !ok {
+			w.fail(cmd, "call to undefined function %s", cmd.Arg1)
+		}
+		w.writef("\tcall(%s, %d)", name, cmd.Arg2)
+	case C_RETURN:
+		w.writef("\tret()")
+		w.writef("\treturn")
+	}
+}
+
+func (w *GoWriter) label(cmd Command) string {
+	label, ok := w.labels[cmd.Arg1]
+	if !ok {
+		w.fail(cmd, "undefined label %s", cmd.Arg1)
+	}
+	return label
+}
+
+// segment returns the Go expression of the RAM word a push or pop accesses.
+func (w *GoWriter) segment(cmd Command) string {
+	index := cmd.Arg2
+	if register, ok := goRegisters[cmd.Arg1]; ok {
+		return fmt.Sprintf("*at(ram[%s] + %d)", register, index)
+	}
+	switch cmd.Arg1 {
+	case "pointer":
+		if index < 0 || 1 < index {
+			w.fail(cmd, "index %d is out of pointer segment (0-1)", index)
+		}
+		return fmt.Sprintf("ram[%d]", 3+index)
+	case "temp":
+		if index < 0 || 7 < index {
+			w.fail(cmd, "index %d is out of temp segment (0-7)", index)
+		}
+		return fmt.Sprintf("ram[%d]", 5+index)
+	case "static":
+		key := fmt.Sprintf("%s.%d", w.currentFile, index)
+		addr, ok := w.statics[key]
+		if !ok {
+			addr = goStaticBase + len(w.statics)
+			w.statics[key] = addr
+		}
+		return fmt.Sprintf("ram[%d]", addr)
+	}
+	w.fail(cmd, "unknown segment %s", cmd.Arg1)
+	return "ram[0]"
+}
+
+// goRuntime is the part of generated programs which doesn't depend on the
+// VM program, which is written in run and functions after it.
+const goRuntime = `
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ram is the memory of the Hack computer.
+var ram [32768]int16
+
+// Addresses of the VM registers.
+const (
+	sp   = 0
+	lcl  = 1
+	arg  = 2
+	this = 3
+	that = 4
+)
+
+var (
+	limit = flag.Int64("limit", 0, "stop after N jumps and calls; 0 means no limit")
+	jumps int64
+	dumps [][2]int
+)
+
+func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [ADDR=VALUE | ADDR | FIRST-LAST]...\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	for _, arg := range flag.Args() {
+		if addr, value, ok := strings.Cut(arg, "="); ok {
+			*at(parse(addr)) = parse(value)
+			continue
+		}
+		first, last, ok := strings.Cut(arg, "-")
+		if !ok {
+			last = first
+		}
+		dumps = append(dumps, [2]int{int(parse(first)), int(parse(last))})
+	}
+	run()
+	halt()
+}
+
+func parse(s string) int16 {
+	n, err := strconv.ParseInt(s, 10, 32)
+	if err != nil || n < -32768 || 65535 < n {
+		fmt.Fprintf(os.Stderr, "invalid argument: %s\n", s)
+		os.Exit(2)
+	}
+	return int16(n)
+}
+
+// halt prints RAM asked by arguments and exits.
+func halt() {
+	for _, d := range dumps {
+		for addr := d[0]; addr <= d[1]; addr++ {
+			fmt.Printf("RAM[%d] = %d\n", addr, *at(int16(addr)))
+		}
+	}
+	os.Exit(0)
+}
+
+func jump() {
+	jumps++
+	if *limit > 0 && jumps > *limit {
+		fmt.Fprintf(os.Stderr, "stopped after %d jumps and calls\n", *limit)
+		os.Exit(1)
+	}
+}
+
+func at(addr int16) *int16 {
+	return &ram[addr&0x7fff]
+}
+
+func push(v int16) {
+	*at(ram[sp]) = v
+	ram[sp]++
+}
+
+func pop() int16 {
+	ram[sp]--
+	return *at(ram[sp])
+}
+
+// top returns the word at the top of the stack.
+func top() *int16 {
+	return at(ram[sp] - 1)
+}
+
+func pushLocals(n int) {
+	for i := 0; i < n; i++ {
+		push(0)
+	}
+}
+
+func call(f func(), nArgs int16) {
+	jump()
+	push(0) // return address
+	push(ram[lcl])
+	push(ram[arg])
+	push(ram[this])
+	push(ram[that])
+	ram[arg] = ram[sp] - nArgs - 5
+	ram[lcl] = ram[sp]
+	f()
+}
+
+func ret() {
+	frame := ram[lcl]
+	*at(ram[arg]) = pop()
+	ram[sp] = ram[arg] + 1
+	ram[that] = *at(frame - 1)
+	ram[this] = *at(frame - 2)
+	ram[arg] = *at(frame - 3)
+	ram[lcl] = *at(frame - 4)
+}
+
+func boolean(b bool) int16 {
+	if b {
+		return -1
+	}
+	return 0
+}
+
+func opAdd() { y := pop(); *top() += y }
+func opSub() { y := pop(); *top() -= y }
+func opNeg() { *top() = -*top() }
+func opAnd() { y := pop(); *top() &= y }
+func opOr()  { y := pop(); *top() |= y }
+func opNot() { *top() = ^*top() }
+func opMul() { y := pop(); *top() *= y }
+
+// difference has the sign of x - y which comparisons test. It wraps around
+// as in Hack code unless exactCompare is true.
+func difference(x, y int16) int {
+	if exactCompare {
+		return int(x) - int(y)
+	}
+	return int(x - y)
+}
+
+func opEq() { y := pop(); *top() = boolean(difference(*top(), y) == 0) }
+func opGt() { y := pop(); *top() = boolean(difference(*top(), y) > 0) }
+func opLt() { y := pop(); *top() = boolean(difference(*top(), y) < 0) }
+func opLe() { y := pop(); *top() = boolean(difference(*top(), y) <= 0) }
+func opGe() { y := pop(); *top() = boolean(difference(*top(), y) >= 0) }
+func opNe() { y := pop(); *top() = boolean(difference(*top(), y) != 0) }
+
+func opDiv() {
+	y := pop()
+	if y == 0 {
+		*top() = 0
+	} else {
+		*top() /= y
+	}
+}
+
+func opShl() {
+	if y := pop(); y >= 0 {
+		*top() <<= y
+	}
+}
+
+func opShr() {
+	if y := pop(); y >= 0 {
+		*top() >>= y
+	}
+}
+
+func opDup()  { push(*top()) }
+func opSwap() { y := pop(); x := pop(); push(y); push(x) }
+`
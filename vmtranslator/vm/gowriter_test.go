@@ -0,0 +1,121 @@
+package vm
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"testing"
+)
+
+var goRAMPattern = regexp.MustCompile(`RAM\[(\d+)\] = (-?\d+)`)
+
+// runGo translates VM files into a Go program with the Extended and
+// ExactCompare options, runs it with args and returns RAM it prints.
+func runGo(t *testing.T, paths []string, opts Options, args ...string) map[int]int16 {
+	t.Helper()
+	if testing.Short() {
+		t.Skip("builds Go programs")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command")
+	}
+	prog, err := LoadProgram(paths, opts.Extended)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var src bytes.Buffer
+	w := NewGoWriter(&src)
+	w.SetExactCompare(opts.ExactCompare)
+	if err := w.WriteProgram(prog, prog.Defines(entryFunction)); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod":  "module generated\n\ngo 1.21\n",
+		"main.go": src.String(),
+	}
+	for name, data := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	build := exec.Command(goTool, "build", "-o", "prog", ".")
+	build.Dir = dir
+	if out, err := build.CombinedOutput(); err != nil {
+		t.Fatalf("cannot build generated program: %v\n%s", err, out)
+	}
+	out, err := exec.Command(filepath.Join(dir, "prog"), args...).Output()
+	if err != nil {
+		t.Fatalf("generated program failed: %v", err)
+	}
+
+	ram := make(map[int]int16)
+	for _, m := range goRAMPattern.FindAllStringSubmatch(string(out), -1) {
+		addr, _ := strconv.Atoi(m[1])
+		value, _ := strconv.Atoi(m[2])
+		ram[addr] = int16(value)
+	}
+	return ram
+}
+
+func checkGoRAM(t *testing.T, got, want map[int]int16) {
+	t.Helper()
+	for addr, value := range want {
+		if got[addr] != value {
+			t.Errorf("want RAM[%d] to be %d, but got %d", addr, value, got[addr])
+		}
+	}
+}
+
+func TestGoWriterProjects(t *testing.T) {
+	for _, dir := range projectTestDirs {
+		dir, name := dir, filepath.Base(dir)
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+			tst, err := os.ReadFile(filepath.Join(dir, name+".tst"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var args []string
+			for _, m := range tstSetPattern.FindAllStringSubmatch(string(tst), -1) {
+				args = append(args, m[1]+"="+m[2])
+			}
+			want := readCmp(t, filepath.Join(dir, name+".cmp"))
+			for addr := range want {
+				args = append(args, strconv.Itoa(addr))
+			}
+			checkGoRAM(t, runGo(t, vmFilesIn(t, dir), Options{}, args...), want)
+		})
+	}
+}
+
+// TestGoWriterMathTest runs the test program of the OS class Math with
+// the OS, which Hack code only finishes in millions of cycles.
+func TestGoWriterMathTest(t *testing.T) {
+	paths := append(vmFilesIn(t, "../../tools/OS"), vmFilesIn(t, "testdata/MathTest")...)
+	got := runGo(t, paths, Options{}, "8000-8013")
+	checkGoRAM(t, got, readCmp(t, "../../projects/12/MathTest/MathTest.cmp"))
+}
+
+func TestGoWriterCommands(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{"Sys.vm": optimizerTestSys})
+	plain := runHack(t, translateFiles(t, nil, paths...), 100000)
+	var args []string
+	for addr := 0; addr < 24; addr++ {
+		args = append(args, strconv.Itoa(addr))
+	}
+	got := runGo(t, paths, Options{}, append(args, "3005", "3017")...)
+	want := make(map[int]int16)
+	for _, addr := range []int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 16, 17, 18, 19, 20, 21, 22, 23, 3005, 3017} {
+		want[addr] = int16(plain.RAM[addr])
+	}
+	checkGoRAM(t, got, want)
+	if len(got) != 26 {
+		t.Errorf("want 26 words printed, but got %d: %v", len(got), got)
+	}
+}
@@ -0,0 +1,205 @@
+package vm
+
+// This file implements the inline expansion of small leaf functions.
+//
+// A call to an inlinable function is replaced by its body. The arguments
+// are popped to locals added to the caller, which also holds the locals of
+// the callee, and THIS and THAT if the callee sets them, since they would be
+// restored by its return. For example, in a caller with 2 locals,
+// `call Square.getX 1` becomes
+//
+//	pop local 2      // argument 0
+//	push pointer 0   // save THIS
+//	pop local 3
+//	push local 2     // push argument 0
+//	pop pointer 0
+//	push this 0
+//	push local 3     // restore THIS
+//	pop pointer 0
+
+// stackEffects are the numbers of values arithmetic commands pop and push.
+var stackEffects = map[string][2]int{
+	"neg":  {1, 1},
+	"not":  {1, 1},
+	"dup":  {1, 2},
+	"swap": {2, 2},
+}
+
+// inlineFunction is the body of an inlinable function.
+type inlineFunction struct {
+	file    string
+	nLocals int
+	body    []Command
+	// nArgs is the number of arguments the body uses.
+	nArgs int
+	// setsPointer[i] is true if the body pops to pointer i.
+	setsPointer [2]bool
+	usesStatic  bool
+}
+
+// Inline replaces calls to functions of up to maxCommands commands which
+// are inlinable, and returns the names of the functions inlined at least
+// once in program order. A function is inlinable if it doesn't call
+// functions, has no labels, and returns once at the end with exactly its
+// return value on the stack. Calls outside functions are kept.
+func (p *Program) Inline(maxCommands int) []string {
+	candidates := make(map[string]*inlineFunction)
+	for _, file := range p.Files {
+		for i, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION {
+				if f := inlinable(file, i, maxCommands); f != nil {
+					candidates[cmd.Arg1] = f
+				}
+			}
+		}
+	}
+
+	inlined := make(map[string]bool)
+	for _, file := range p.Files {
+		var commands []Command
+		function := -1 // index of the caller in commands
+		extraLocals := 0
+		finishFunction := func() {
+			if function >= 0 {
+				commands[function].Arg2 += extraLocals
+			}
+		}
+		for _, cmd := range file.Commands {
+			switch cmd.Type {
+			case C_FUNCTION:
+				finishFunction()
+				function = len(commands)
+				extraLocals = 0
+			case C_CALL:
+				f := candidates[cmd.Arg1]
+				if function < 0 || f == nil || cmd.Arg2 < f.nArgs || f.usesStatic && f.file != file.Name {
+					break
+				}
+				base := commands[function].Arg2
+				commands = append(commands, f.expand(cmd, base)...)
+				extraLocals = max(extraLocals, f.frameSize(cmd.Arg2))
+				inlined[cmd.Arg1] = true
+				continue
+			}
+			commands = append(commands, cmd)
+		}
+		finishFunction()
+		file.Commands = commands
+	}
+
+	var names []string
+	for _, file := range p.Files {
+		for _, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION && inlined[cmd.Arg1] {
+				names = append(names, cmd.Arg1)
+			}
+		}
+	}
+	return names
+}
+
+// inlinable returns the function defined at file.Commands[start]
+// if it can be inlined.
+func inlinable(file *VMFile, start, maxCommands int) *inlineFunction {
+	f := &inlineFunction{file: file.Name, nLocals: file.Commands[start].Arg2}
+	depth := 0
+	for _, cmd := range file.Commands[start+1:] {
+		switch cmd.Type {
+		case C_RETURN:
+			if depth != 1 || len(f.body) > maxCommands {
+				return nil
+			}
+			return f
+		case C_PUSH:
+			depth++
+		case C_POP:
+			depth--
+		case C_ARITHMETIC:
+			effect, ok := stackEffects[cmd.Arg1]
+			if !ok {
+				effect = [2]int{2, 1}
+			}
+			if depth < effect[0] {
+				return nil
+			}
+			depth += effect[1] - effect[0]
+		default:
+			return nil
+		}
+		if depth < 0 {
+			return nil
+		}
+		switch {
+		case cmd.Arg1 == "local" && cmd.Arg2 >= f.nLocals:
+			return nil
+		case cmd.Arg1 == "argument":
+			f.nArgs = max(f.nArgs, cmd.Arg2+1)
+		case cmd.Arg1 == "static":
+			f.usesStatic = true
+		case cmd.Type == C_POP && cmd.Arg1 == "pointer" && (cmd.Arg2 == 0 || cmd.Arg2 == 1):
+			f.setsPointer[cmd.Arg2] = true
+		}
+		f.body = append(f.body, cmd)
+	}
+	return nil
+}
+
+// frameSize returns the number of locals the caller needs for the callee
+// called with nArgs arguments.
+func (f *inlineFunction) frameSize(nArgs int) int {
+	size := nArgs + f.nLocals
+	for _, sets := range f.setsPointer {
+		if sets {
+			size++
+		}
+	}
+	return size
+}
+
+// expand returns the commands replacing call, with the frame of the callee
+// from local base of the caller.
+func (f *inlineFunction) expand(call Command, base int) []Command {
+	var cmds []Command
+	add := func(typ CommandType, segment string, index int) {
+		cmds = append(cmds, Command{Type: typ, Arg1: segment, Arg2: index, Line: call.Line})
+	}
+
+	nArgs := call.Arg2
+	for i := nArgs - 1; i >= 0; i-- {
+		add(C_POP, "local", base+i)
+	}
+	var saved [2]int
+	next := base + nArgs + f.nLocals
+	for i, sets := range f.setsPointer {
+		if sets {
+			saved[i] = next
+			next++
+			add(C_PUSH, "pointer", i)
+			add(C_POP, "local", saved[i])
+		}
+	}
+	for i := 0; i < f.nLocals; i++ {
+		add(C_PUSH, "constant", 0)
+		add(C_POP, "local", base+nArgs+i)
+	}
+
+	for _, cmd := range f.body {
+		switch cmd.Arg1 {
+		case "argument":
+			cmd.Arg1 = "local"
+			cmd.Arg2 += base
+		case "local":
+			cmd.Arg2 += base + nArgs
+		}
+		cmd.Line = call.Line
+		cmds = append(cmds, cmd)
+	}
+
+	for i := len(f.setsPointer) - 1; i >= 0; i-- {
+		if f.setsPointer[i] {
+			add(C_PUSH, "local", saved[i])
+			add(C_POP, "pointer", i)
+		}
+	}
+	return cmds
+}
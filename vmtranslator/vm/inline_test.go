@@ -0,0 +1,147 @@
+package vm
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+var inlineTestFiles = map[string]string{
+	"Sys.vm": `
+function Sys.init 1
+	push constant 4000
+	pop pointer 1
+	push constant 42
+	pop that 0
+	push constant 3000
+	pop pointer 0
+	push constant 4000
+	call Point.getX 1
+	pop static 0          // 42
+	push pointer 0
+	pop static 1          // 3000, THIS is restored
+	push constant 1
+	push constant 2
+	push constant 3
+	call Point.sum 3
+	pop local 0
+	push local 0
+	pop static 2          // 6
+	push constant 5
+	neg
+	call Point.abs 1
+	pop static 3          // 5
+	call Point.count 0
+	pop static 4          // 1
+	push constant 3
+	call Point.twice 1
+	pop static 5          // 6
+label HALT
+	goto HALT
+`,
+	"Point.vm": `
+function Point.getX 0
+	push argument 0
+	pop pointer 0
+	push this 0
+	return
+function Point.sum 1
+	push argument 0
+	push argument 1
+	add
+	pop local 0
+	push local 0
+	push argument 2
+	add
+	return
+function Point.abs 0
+	push argument 0
+	push constant 0
+	lt
+	if-goto NEG
+	push argument 0
+	return
+label NEG
+	push argument 0
+	neg
+	return
+function Point.count 0
+	push static 0
+	push constant 1
+	add
+	pop static 0
+	push static 0
+	return
+function Point.twice 0
+	push argument 0
+	push argument 0
+	push constant 0
+	call Point.sum 3
+	return
+`,
+}
+
+func TestInline(t *testing.T) {
+	paths := writeVMFiles(t, inlineTestFiles)
+	prog, err := LoadProgram(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prog.Inline(10), []string{"Point.getX", "Point.sum"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("inlined %v, want %v", got, want)
+	}
+	for _, file := range prog.Files {
+		for _, cmd := range file.Commands {
+			if cmd.Type == C_CALL && (cmd.Arg1 == "Point.getX" || cmd.Arg1 == "Point.sum") {
+				t.Errorf("%s:%d: %s is not inlined", file.Name, cmd.Line, cmd)
+			}
+			if cmd.Type == C_FUNCTION && cmd.Arg1 == "Sys.init" && cmd.Arg2 != 1+4 {
+				t.Errorf("want Sys.init to have 5 locals for Point.sum, but got %d", cmd.Arg2)
+			}
+		}
+	}
+
+	plain := runHack(t, translateFiles(t, nil, paths...), 100000)
+	wants := map[int]int16{16: 42, 17: 3000, 18: 6, 19: 5, 20: 1, 21: 6}
+	for addr, want := range wants {
+		if got := int16(plain.RAM[addr]); got != want {
+			t.Errorf("plain: want RAM[%d] to be %d, but got %d", addr, want, got)
+		}
+	}
+
+	for name, configure := range map[string]func(*CodeWriter){
+		"plain":   nil,
+		"tos":     cachedTop,
+		"compact": optimizedAndCompacted,
+	} {
+		t.Run(name, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewCodeWriter(&buf)
+			if configure != nil {
+				configure(w)
+			}
+			if err := TranslateProgram(prog, w, true); err != nil {
+				t.Fatal(err)
+			}
+			cpu := runHack(t, buf.String(), 100000)
+			for addr, want := range wants {
+				if got := int16(cpu.RAM[addr]); got != want {
+					t.Errorf("want RAM[%d] to be %d, but got %d", addr, want, got)
+				}
+			}
+			if name == "plain" && cpu.Cycles >= plain.Cycles {
+				t.Errorf("inlined code is not faster: %d >= %d cycles", cpu.Cycles, plain.Cycles)
+			}
+		})
+	}
+}
+
+func TestInlineThreshold(t *testing.T) {
+	prog, err := LoadProgram(writeVMFiles(t, inlineTestFiles), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := prog.Inline(3), []string{"Point.getX"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("inlined %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,320 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// This file lifts Hack assembly written by a CodeWriter without options
+// back to VM commands. Templates of commands are written by a CodeWriter
+// with sentinel arguments, which become capture groups, so that lifting
+// follows the code templates as they change.
+
+// Sentinel arguments of templates.
+const (
+	liftFile     = "LIFT_FILE"
+	liftFunction = "LIFT_FUNCTION"
+	liftLabel    = "LIFT_LABEL"
+	liftIndex    = 23456
+)
+
+// liftSentinels replaces sentinels in templates with capture groups, in
+// order. Groups of the same name must capture the same text within a
+// template: name is Arg1, index is Arg2 and frame is Arg2 of calls plus
+// the saved frame.
+var liftSentinels = []struct{ sentinel, pattern string }{
+	{liftFile + "." + liftFunction + "$" + liftLabel, `[^$]*\$(?P<name>[^$]+)`},
+	{liftFile + ".static_" + strconv.Itoa(liftIndex), `(?P<file>.+)\.static_(?P<index>\d+)`},
+	{strconv.Itoa(liftIndex + savedFrameSize), `(?P<frame>\d+)`},
+	{strconv.Itoa(liftIndex), `(?P<index>\d+)`},
+	{liftFunction, `(?P<name>[^$]+)`},
+	{"END_SET_TRUE_0", `(?P<endSetTrue>.+)`},
+	{"RETURN_ADDR_0", `(?P<returnAddress>.+)`},
+}
+
+// liftTemplate matches the instructions of a command.
+type liftTemplate struct {
+	command Command
+	lines   []*regexp.Regexp
+}
+
+// liftTemplates are the templates of Lift: bootstrap is the bootstrap code
+// and pushD pushes the local variables of functions after function
+// with 0 locals.
+type liftTemplates struct {
+	bootstrap *liftTemplate
+	function  *liftTemplate
+	pushD     *liftTemplate
+	commands  []*liftTemplate
+}
+
+var getLiftTemplates = sync.OnceValue(func() *liftTemplates {
+	t := &liftTemplates{
+		bootstrap: newLiftTemplate(Command{}, (*CodeWriter).WriteInit),
+		pushD:     newLiftTemplate(Command{}, (*CodeWriter).writePushDUnchecked),
+	}
+	t.function = newLiftTemplate(Command{Type: C_FUNCTION}, func(w *CodeWriter) {
+		w.WriteFunction(liftFunction, 0)
+	})
+
+	add := func(cmd Command) {
+		t.commands = append(t.commands, newLiftTemplate(cmd, func(w *CodeWriter) {
+			w.WriteCommand(cmd)
+		}))
+	}
+	for _, cmdType := range []CommandType{C_PUSH, C_POP} {
+		for _, segment := range []string{"constant", "local", "argument", "this", "that", "static"} {
+			if cmdType == C_POP && segment == "constant" {
+				continue
+			}
+			add(Command{Type: cmdType, Arg1: segment, Arg2: liftIndex})
+		}
+		for i := 0; i < 8; i++ {
+			add(Command{Type: cmdType, Arg1: "temp", Arg2: i})
+		}
+		for i := 0; i < 2; i++ {
+			add(Command{Type: cmdType, Arg1: "pointer", Arg2: i})
+		}
+	}
+	for _, command := range []string{"add", "sub", "neg", "eq", "gt", "lt", "and", "or", "not"} {
+		add(Command{Type: C_ARITHMETIC, Arg1: command})
+	}
+	add(Command{Type: C_LABEL, Arg1: liftLabel})
+	add(Command{Type: C_GOTO, Arg1: liftLabel})
+	add(Command{Type: C_IF, Arg1: liftLabel})
+	add(Command{Type: C_CALL, Arg1: liftFunction, Arg2: liftIndex})
+	add(Command{Type: C_RETURN})
+	return t
+})
+
+// newLiftTemplate returns the template of cmd written by write.
+func newLiftTemplate(cmd Command, write func(*CodeWriter)) *liftTemplate {
+	var buf bytes.Buffer
+	w := NewCodeWriter(&buf)
+	w.SetFilename(liftFile)
+	w.currentFunction = liftFunction
+	write(w)
+
+	t := &liftTemplate{command: cmd}
+	for _, line := range asmInstructions(buf.String()) {
+		pattern := regexp.QuoteMeta(line.text)
+		for _, s := range liftSentinels {
+			pattern = strings.ReplaceAll(pattern, regexp.QuoteMeta(s.sentinel), s.pattern)
+		}
+		t.lines = append(t.lines, regexp.MustCompile("^"+pattern+"$"))
+	}
+	return t
+}
+
+// match matches the template with instructions from lines[i] and returns
+// the captured groups, or nil if it doesn't match.
+func (t *liftTemplate) match(lines []asmLine, i int) map[string]string {
+	if i+len(t.lines) > len(lines) {
+		return nil
+	}
+	groups := make(map[string]string)
+	for j, re := range t.lines {
+		m := re.FindStringSubmatch(lines[i+j].text)
+		if m == nil {
+			return nil
+		}
+		for k, name := range re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if s, ok := groups[name]; ok && s != m[k] {
+				return nil
+			}
+			groups[name] = m[k]
+		}
+	}
+	return groups
+}
+
+// liftCommand returns the command of the template with captured groups.
+func (t *liftTemplate) liftCommand(groups map[string]string) (Command, bool) {
+	cmd := t.command
+	if name, ok := groups["name"]; ok {
+		cmd.Arg1 = name
+	}
+	if s, ok := groups["index"]; ok {
+		index, err := strconv.Atoi(s)
+		if err != nil {
+			return Command{}, false
+		}
+		cmd.Arg2 = index
+	}
+	if s, ok := groups["frame"]; ok {
+		frame, err := strconv.Atoi(s)
+		if err != nil || frame < savedFrameSize {
+			return Command{}, false
+		}
+		cmd.Arg2 = frame - savedFrameSize
+	}
+	return cmd, true
+}
+
+// asmLine is an instruction or a label of Hack assembly without comments
+// and whitespace.
+type asmLine struct {
+	text string
+	line int
+}
+
+func asmInstructions(src string) []asmLine {
+	var lines []asmLine
+	for i, line := range strings.Split(src, "\n") {
+		if j := strings.Index(line, "//"); j >= 0 {
+			line = line[:j]
+		}
+		if text := strings.Join(strings.Fields(line), ""); text != "" {
+			lines = append(lines, asmLine{text: text, line: i + 1})
+		}
+	}
+	return lines
+}
+
+// LiftedProgram is VM code which Lift recovered from Hack assembly.
+type LiftedProgram struct {
+	// Bootstrap is true if the assembly starts with bootstrap code.
+	Bootstrap bool
+	// Commands are lifted commands, whose Line is the line of the first
+	// instruction in the assembly.
+	Commands []Command
+	// Unlifted are regions of the assembly which match no template.
+	Unlifted []UnliftedRegion
+}
+
+// UnliftedRegion is instructions between lines Start and End of the
+// assembly, which are before Commands[Before].
+type UnliftedRegion struct {
+	Start, End   int
+	Before       int
+	Instructions []string
+}
+
+// Lift recovers VM commands from Hack assembly written by a CodeWriter
+// without options, and the regions which it can't lift. Static variables
+// of different files are numbered in order of appearance, so that the
+// commands are a VM file equivalent to the assembly.
+func Lift(r io.Reader) (*LiftedProgram, error) {
+	src, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	lines := asmInstructions(string(src))
+	templates := getLiftTemplates()
+
+	prog := &LiftedProgram{}
+	var staticFiles []string // file of each static command
+	i := 0
+	if templates.bootstrap.match(lines, 0) != nil {
+		prog.Bootstrap = true
+		i = len(templates.bootstrap.lines)
+	}
+	for i < len(lines) {
+		cmd, file, n := templates.lift(lines, i)
+		if n == 0 {
+			last := len(prog.Unlifted) - 1
+			if last < 0 || prog.Unlifted[last].Before != len(prog.Commands) {
+				prog.Unlifted = append(prog.Unlifted, UnliftedRegion{Start: lines[i].line, Before: len(prog.Commands)})
+				last++
+			}
+			prog.Unlifted[last].End = lines[i].line
+			prog.Unlifted[last].Instructions = append(prog.Unlifted[last].Instructions, lines[i].text)
+			i++
+			continue
+		}
+		cmd.Line = lines[i].line
+		prog.Commands = append(prog.Commands, cmd)
+		staticFiles = append(staticFiles, file)
+		i += n
+	}
+	prog.renumberStatics(staticFiles)
+	return prog, nil
+}
+
+// lift lifts the command at lines[i] and returns it with the file of its
+// static variable and the number of lifted lines, or 0 lines if no
+// template matches.
+func (t *liftTemplates) lift(lines []asmLine, i int) (Command, string, int) {
+	if groups := t.function.match(lines, i); groups != nil {
+		cmd, _ := t.function.liftCommand(groups)
+		n := len(t.function.lines)
+		for t.pushD.match(lines, i+n) != nil {
+			cmd.Arg2++
+			n += len(t.pushD.lines)
+		}
+		return cmd, "", n
+	}
+	for _, template := range t.commands {
+		groups := template.match(lines, i)
+		if groups == nil {
+			continue
+		}
+		if cmd, ok := template.liftCommand(groups); ok {
+			return cmd, groups["file"], len(template.lines)
+		}
+	}
+	return Command{}, "", 0
+}
+
+// renumberStatics numbers static variables of different files in order of
+// appearance, and keeps their indices if they are of a single file.
+func (p *LiftedProgram) renumberStatics(files []string) {
+	type static struct {
+		file  string
+		index int
+	}
+	indices := make(map[static]int)
+	var firstFile string
+	singleFile := true
+	for i, cmd := range p.Commands {
+		if cmd.Arg1 != "static" || (cmd.Type != C_PUSH && cmd.Type != C_POP) {
+			continue
+		}
+		if len(indices) == 0 {
+			firstFile = files[i]
+		} else if files[i] != firstFile {
+			singleFile = false
+		}
+		s := static{files[i], cmd.Arg2}
+		if _, ok := indices[s]; !ok {
+			indices[s] = len(indices)
+		}
+	}
+	if singleFile {
+		return
+	}
+	for i, cmd := range p.Commands {
+		if cmd.Arg1 == "static" && (cmd.Type == C_PUSH || cmd.Type == C_POP) {
+			p.Commands[i].Arg2 = indices[static{files[i], cmd.Arg2}]
+		}
+	}
+}
+
+// WriteVM writes the lifted commands as a VM file, with unlifted regions
+// as comments where they are.
+func (p *LiftedProgram) WriteVM(out io.Writer) error {
+	bw := bufio.NewWriter(out)
+	regions := p.Unlifted
+	for i := 0; i <= len(p.Commands); i++ {
+		for len(regions) > 0 && regions[0].Before == i {
+			fmt.Fprintf(bw, "// cannot lift lines %d-%d:\n", regions[0].Start, regions[0].End)
+			for _, instruction := range regions[0].Instructions {
+				fmt.Fprintf(bw, "//\t%s\n", instruction)
+			}
+			regions = regions[1:]
+		}
+		if i < len(p.Commands) {
+			fmt.Fprintln(bw, p.Commands[i])
+		}
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,179 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const liftTestMain = `
+function Main.f 2
+	push constant 7
+	push local 1
+	push argument 2
+	push this 3
+	push that 4
+	push temp 5
+	push pointer 1
+	push static 3
+	pop local 1
+	pop argument 2
+	pop this 3
+	pop that 4
+	pop temp 7
+	pop pointer 0
+	pop static 3
+	add
+	sub
+	neg
+	eq
+	gt
+	lt
+	and
+	or
+	not
+label LOOP
+	goto LOOP
+	if-goto LOOP
+	call Main.g 2
+	return
+function Main.g 0
+	push constant 32767
+	return
+`
+
+// liftString translates src and lifts it, with lines of commands cleared.
+func liftString(t *testing.T, src string) *LiftedProgram {
+	t.Helper()
+	prog, err := Lift(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range prog.Commands {
+		prog.Commands[i].Line = 0
+	}
+	return prog
+}
+
+func parseCommands(t *testing.T, files map[string]string) []Command {
+	t.Helper()
+	readers := make(map[string]io.Reader)
+	for name, src := range files {
+		readers[name] = strings.NewReader(src)
+	}
+	prog, err := ParseProgram(readers, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cmds []Command
+	for _, file := range prog.Files {
+		for _, cmd := range file.Commands {
+			cmd.Line = 0
+			cmds = append(cmds, cmd)
+		}
+	}
+	return cmds
+}
+
+func TestLift(t *testing.T) {
+	src := translateFiles(t, nil, writeVMFiles(t, map[string]string{"Main.vm": liftTestMain})...)
+	got := liftString(t, src)
+	if got.Bootstrap || len(got.Unlifted) > 0 {
+		t.Errorf("want no bootstrap and unlifted regions, but got %t and %v", got.Bootstrap, got.Unlifted)
+	}
+	if want := parseCommands(t, map[string]string{"Main.vm": liftTestMain}); !reflect.DeepEqual(got.Commands, want) {
+		t.Errorf("want commands\n%v\nbut got\n%v", want, got.Commands)
+	}
+}
+
+func TestLiftRenumbersStatics(t *testing.T) {
+	files := map[string]string{
+		"A.vm": "function A.f 0\npush static 1\npop static 0\npush static 1\nreturn\n",
+		"B.vm": "function B.f 0\npush static 1\npop static 0\nreturn\n",
+	}
+	got := liftString(t, translateFiles(t, nil, writeVMFiles(t, files)...))
+	var statics []int
+	for _, cmd := range got.Commands {
+		if cmd.Arg1 == "static" {
+			statics = append(statics, cmd.Arg2)
+		}
+	}
+	if want := []int{0, 1, 0, 2, 3}; !reflect.DeepEqual(statics, want) {
+		t.Errorf("want statics %v, but got %v", want, statics)
+	}
+
+	got = liftString(t, translateFiles(t, nil, writeVMFiles(t, map[string]string{"A.vm": files["A.vm"]})...))
+	if cmd := got.Commands[1]; cmd.Arg2 != 1 {
+		t.Errorf("want static 1 of a single file kept, but got %v", cmd)
+	}
+}
+
+func TestLiftUnliftable(t *testing.T) {
+	src := strings.Join([]string{
+		"@7", "D=A", "@SP", "A=M", "M=D", "@SP", "M=M+1", // push constant 7
+		"@42", "D=D+1 // not a command", "",
+		"@SP", "A=M-1", "M=-M", // neg
+	}, "\n")
+	got := liftString(t, src)
+	want := &LiftedProgram{
+		Commands: []Command{
+			{Type: C_PUSH, Arg1: "constant", Arg2: 7},
+			{Type: C_ARITHMETIC, Arg1: "neg"},
+		},
+		Unlifted: []UnliftedRegion{{Start: 8, End: 9, Before: 1, Instructions: []string{"@42", "D=D+1"}}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("want %+v, but got %+v", want, got)
+	}
+
+	var buf bytes.Buffer
+	if err := got.WriteVM(&buf); err != nil {
+		t.Fatal(err)
+	}
+	wantVM := "push constant 7\n// cannot lift lines 8-9:\n//\t@42\n//\tD=D+1\nneg\n"
+	if buf.String() != wantVM {
+		t.Errorf("want VM\n%s\nbut got\n%s", wantVM, buf.String())
+	}
+
+	optimizedSrc := translateFiles(t, optimized, writeVMFiles(t, map[string]string{"Main.vm": liftTestMain})...)
+	if got := liftString(t, optimizedSrc); len(got.Unlifted) == 0 {
+		t.Error("want unlifted regions in optimized code")
+	}
+}
+
+// TestLiftProjects translates the course's programs, lifts them and checks
+// that the lifted programs pass the test scripts and lift to themselves.
+func TestLiftProjects(t *testing.T) {
+	for _, dir := range projectTestDirs {
+		name := filepath.Base(dir)
+		t.Run(name, func(t *testing.T) {
+			paths := vmFilesIn(t, dir)
+			lifted := liftString(t, translateFiles(t, nil, paths...))
+			if len(lifted.Unlifted) > 0 {
+				t.Fatalf("unlifted regions: %+v", lifted.Unlifted)
+			}
+			prog, err := LoadProgram(paths, false)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := prog.Defines(entryFunction); lifted.Bootstrap != want {
+				t.Errorf("want bootstrap %t, but got %t", want, lifted.Bootstrap)
+			}
+
+			var vmSrc bytes.Buffer
+			if err := lifted.WriteVM(&vmSrc); err != nil {
+				t.Fatal(err)
+			}
+			src := translateFiles(t, nil, writeVMFiles(t, map[string]string{"Lifted.vm": vmSrc.String()})...)
+			cpu := runTst(t, src, filepath.Join(dir, name+".tst"))
+			checkCmp(t, cpu, filepath.Join(dir, name+".cmp"))
+
+			if relifted := liftString(t, src); !reflect.DeepEqual(relifted, lifted) {
+				t.Errorf("want the lifted program to lift to itself, but got\n%v\nfrom\n%v", relifted.Commands, lifted.Commands)
+			}
+		})
+	}
+}
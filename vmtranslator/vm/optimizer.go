@@ -0,0 +1,360 @@
+package vm
+
+import (
+	"strconv"
+	"strings"
+)
+
+// This file implements the optimizing templates enabled by SetOptimize.
+// They differ from the plain ones in two ways:
+//
+//   - Single commands use shorter sequences, e.g. pops to fixed addresses
+//     and to small indices don't go through R13 and R14.
+//   - Common command sequences emitted by the Jack compiler are fused,
+//     e.g. `lt; not; if-goto L` pops both operands and jumps at once
+//     instead of materializing a boolean on the stack.
+
+// maxChainedIndex is the largest segment index which is addressed by
+// incrementing A instead of computing the address in R13.
+const maxChainedIndex = 4
+
+var segmentBaseSymbols = map[string]string{
+	"argument": "ARG",
+	"local":    "LCL",
+	"this":     "THIS",
+	"that":     "THAT",
+}
+
+var binaryOperators = map[string]string{
+	"add": "M=D+M",
+	"sub": "M=M-D",
+	"and": "M=D&M",
+	"or":  "M=D|M",
+}
+
+// compareJumps maps comparisons to jumps taken when they are true on x - y.
+var compareJumps = map[string]string{
+	"eq": "JEQ",
+	"gt": "JGT",
+	"lt": "JLT",
+	"le": "JLE",
+	"ge": "JGE",
+	"ne": "JNE",
+}
+
+var negatedJumps = map[string]string{
+	"JEQ": "JNE",
+	"JGT": "JLE",
+	"JLT": "JGE",
+	"JLE": "JGT",
+	"JGE": "JLT",
+	"JNE": "JEQ",
+}
+
+func isArithmetic(cmd Command, commands ...string) bool {
+	if cmd.Type != C_ARITHMETIC {
+		return false
+	}
+	for _, c := range commands {
+		if cmd.Arg1 == c {
+			return true
+		}
+	}
+	return false
+}
+
+func isCompare(cmd Command) bool {
+	return isArithmetic(cmd, "eq", "gt", "lt", "le", "ge", "ne")
+}
+
+// writeOptimized writes leading commands fused into one sequence.
+// It returns the number of written commands, or 0 when no pattern matches.
+func (w *CodeWriter) writeOptimized(cmds []Command) int {
+	at := func(i int) Command {
+		if i < len(cmds) {
+			return cmds[i]
+		}
+		return Command{Type: -1}
+	}
+	first, second, third := at(0), at(1), at(2)
+
+	switch {
+	case isCompare(first) && isArithmetic(second, "not") && third.Type == C_IF:
+		w.writeComment(first, second, third)
+		w.writeCompareJump(first.Arg1, true, third.Arg1)
+		return 3
+	case isCompare(first) && second.Type == C_IF:
+		w.writeComment(first, second)
+		w.writeCompareJump(first.Arg1, false, second.Arg1)
+		return 2
+	case isArithmetic(first, "not") && second.Type == C_IF:
+		// not x is nonzero unless x is -1 (true).
+		w.writeComment(first, second)
+		w.writef("@SP")
+		w.writef("AM=M-1")
+		w.writef("D=M+1")
+		w.writef("@%s", w.qualifyLabel(second.Arg1))
+		w.writef("D;JNE")
+		w.writef("")
+		return 2
+	case first.Type == C_PUSH && first.Arg1 == "constant" && isArithmetic(second, "add", "sub", "and", "or"):
+		w.writeComment(first, second)
+		w.writeConstantOperation(first.Arg2, second.Arg1)
+		return 2
+	case first.Type == C_PUSH && second.Type == C_POP && !w.checksPointer(first.Arg1) && !w.checksPointer(second.Arg1):
+		w.writeComment(first, second)
+		w.writeMove(first.Arg1, first.Arg2, second.Arg1, second.Arg2)
+		return 2
+	case isCompare(first) && !w.compact:
+		w.writeComment(first)
+		w.writeCompare(first.Arg1)
+		return 1
+	case first.Type == C_FUNCTION:
+		w.writeFunctionOptimized(first.Arg1, first.Arg2)
+		return 1
+	}
+	return 0
+}
+
+func (w *CodeWriter) writeComment(cmds ...Command) {
+	var ss []string
+	for _, cmd := range cmds {
+		ss = append(ss, cmd.String())
+	}
+	w.writef("// %s", strings.Join(ss, "; "))
+}
+
+// writeCompareJump pops x and y and jumps to label if `x cmp y` holds,
+// or if it doesn't when negate is true.
+func (w *CodeWriter) writeCompareJump(cmp string, negate bool, label string) {
+	jump := compareJumps[cmp]
+	if negate {
+		jump = negatedJumps[jump]
+	}
+	w.writef("@SP") // pop y
+	w.writef("AM=M-1")
+	w.writef("D=M")
+	if w.isExactCompare(cmp) {
+		w.writef("@SP") // pop x
+		w.writef("M=M-1")
+		w.writeExactDifference(w.pointAtSP)
+	} else {
+		w.writef("@SP") // pop x
+		w.writef("AM=M-1")
+		w.writef("D=M-D") // D = x - y
+	}
+	w.writef("@%s", w.qualifyLabel(label))
+	w.writef("D;%s", jump)
+	w.writef("")
+}
+
+// writeCompare replaces x and y with the boolean `x cmp y`.
+func (w *CodeWriter) writeCompare(cmp string) {
+	endSetFalseLabel := w.genSequencialLabel("END_SET_FALSE")
+
+	w.writef("@SP") // pop y
+	w.writef("AM=M-1")
+	w.writef("D=M")
+	if w.isExactCompare(cmp) {
+		w.writeExactDifference(w.pointBelowTop)
+		w.pointBelowTop()
+	} else {
+		w.writef("A=A-1") // point x
+		w.writef("D=M-D") // D = x - y
+	}
+	w.writef("M=-1") // x = true
+	w.writef("@%s", endSetFalseLabel)
+	w.writef("D;%s", compareJumps[cmp])
+	w.writef("@SP")
+	w.writef("A=M-1") // point x
+	w.writef("M=0")   // x = false
+	w.writef("(%s)", endSetFalseLabel)
+	w.writef("")
+}
+
+// writeConstantOperation applies `x op constant` in place.
+func (w *CodeWriter) writeConstantOperation(constant int, op string) {
+	if constant == 1 && (op == "add" || op == "sub") {
+		w.writef("@SP")
+		w.writef("A=M-1")
+		if op == "add" {
+			w.writef("M=M+1")
+		} else {
+			w.writef("M=M-1")
+		}
+		w.writef("")
+		return
+	}
+	w.writef("@%d", constant)
+	w.writef("D=A")
+	w.writef("@SP")
+	w.writef("A=M-1")
+	w.writef("%s", binaryOperators[op])
+	w.writef("")
+}
+
+// writeMove writes `push src; pop dst` without touching the stack.
+func (w *CodeWriter) writeMove(srcSegment string, srcIndex int, dstSegment string, dstIndex int) {
+	if dstSegment == "constant" {
+		w.writef("")
+		return
+	}
+	base, isBased := segmentBaseSymbols[dstSegment]
+	if isBased && dstIndex > maxChainedIndex {
+		w.writeAddress(base, dstIndex)
+		w.writef("@R13")
+		w.writef("M=D")
+		w.writeLoad(srcSegment, srcIndex)
+		w.writef("@R13")
+		w.writef("A=M")
+		w.writef("M=D")
+	} else {
+		w.writeLoad(srcSegment, srcIndex)
+		w.writeStore(dstSegment, dstIndex)
+	}
+	w.writef("")
+}
+
+func (w *CodeWriter) writePushOptimized(segment string, index int) {
+	if segment == "constant" && index <= 1 {
+		w.writeStackCheck(1)
+		w.writef("@SP")
+		w.writef("AM=M+1")
+		w.writef("A=A-1")
+		w.writef("M=%d", index)
+		return
+	}
+	w.writeLoad(segment, index)
+	w.writePushD()
+}
+
+func (w *CodeWriter) writePopOptimized(segment string, index int) {
+	if segment == "constant" {
+		w.writef("@SP")
+		w.writef("M=M-1")
+		return
+	}
+	base, isBased := segmentBaseSymbols[segment]
+	if isBased && index > maxChainedIndex {
+		w.writeAddress(base, index)
+		w.writef("@R13")
+		w.writef("M=D")
+		w.writef("@SP")
+		w.writef("AM=M-1")
+		w.writef("D=M")
+		w.writef("@R13")
+		w.writef("A=M")
+		w.writef("M=D")
+		return
+	}
+	w.writef("@SP")
+	w.writef("AM=M-1")
+	w.writef("D=M")
+	w.writeStore(segment, index)
+}
+
+// writeAddress sets D to the address of base segment[index].
+func (w *CodeWriter) writeAddress(base string, index int) {
+	w.writef("@%d", index)
+	w.writef("D=A")
+	w.writef("@%s", base)
+	w.writef("D=D+M")
+}
+
+// writeLoad sets D to segment[index].
+func (w *CodeWriter) writeLoad(segment string, index int) {
+	if segment == "constant" {
+		w.writef("@%d", index)
+		w.writef("D=A")
+		return
+	}
+	base, isBased := segmentBaseSymbols[segment]
+	if !isBased {
+		w.writef("@%s", w.fixedSegmentSymbol(segment, index))
+		w.writef("D=M")
+		return
+	}
+	switch index {
+	case 0:
+		w.writef("@%s", base)
+		w.writef("A=M")
+	case 1:
+		w.writef("@%s", base)
+		w.writef("A=M+1")
+	default:
+		w.writef("@%d", index)
+		w.writef("D=A")
+		w.writef("@%s", base)
+		w.writef("A=D+M")
+	}
+	w.writef("D=M")
+}
+
+// writeStore sets segment[index] to D. Based segments must not have an index
+// larger than maxChainedIndex.
+func (w *CodeWriter) writeStore(segment string, index int) {
+	base, isBased := segmentBaseSymbols[segment]
+	if !isBased {
+		w.writef("@%s", w.fixedSegmentSymbol(segment, index))
+		w.writef("M=D")
+		return
+	}
+	w.writef("@%s", base)
+	if index == 0 {
+		w.writef("A=M")
+	} else {
+		w.writef("A=M+1")
+		for i := 1; i < index; i++ {
+			w.writef("A=A+1")
+		}
+	}
+	w.writef("M=D")
+}
+
+// fixedSegmentSymbol returns the symbol of static, pointer and temp segments.
+func (w *CodeWriter) fixedSegmentSymbol(segment string, index int) string {
+	switch segment {
+	case "static":
+		return w.staticSymbol(index)
+	case "pointer":
+		if index == 0 {
+			return "THIS"
+		} else if index == 1 {
+			return "THAT"
+		}
+		w.fail("index %d is out of pointer segment (0-1)", index)
+	case "temp":
+		// temp segment is R5 ~ R12
+		if index < 0 || 7 < index {
+			w.fail("index %d is out of temp segment (0-7)", index)
+		}
+		return "R" + strconv.Itoa(index+5)
+	}
+	w.fail("unknown segment %s", segment)
+	return ""
+}
+
+// writeFunctionOptimized initializes locals without updating SP for each.
+func (w *CodeWriter) writeFunctionOptimized(funcName string, nLocals int) {
+	w.currentFunction = funcName
+
+	w.writef("// function %s %d", funcName, nLocals)
+	w.writef("(%s) // {", funcName)
+
+	if nLocals > 0 {
+		w.writeStackCheck(nLocals)
+		w.writef("@SP")
+		w.writef("A=M")
+		for i := 0; i < nLocals; i++ {
+			if i > 0 {
+				w.writef("A=A+1")
+			}
+			w.writef("M=0")
+		}
+		w.writef("D=A+1")
+		w.writef("@SP")
+		w.writef("M=D")
+	}
+
+	w.writef("")
+}
@@ -0,0 +1,162 @@
+package vm
+
+import (
+	"path/filepath"
+	"testing"
+
+	"assembler/emulator"
+)
+
+const optimizerTestSys = `
+function Sys.init 8
+	push constant 3000
+	pop pointer 0
+	push constant 3010
+	pop pointer 1
+	push constant 7
+	push constant 8
+	add
+	pop static 0          // 15
+	push constant 100
+	push constant 1
+	sub
+	pop local 6           // 99
+	push local 6
+	pop this 5            // 99
+	push static 0
+	pop that 7            // 15
+	push constant 5
+	neg
+	push constant 32
+	and
+	pop temp 2            // 32 & -5 = 32
+	push constant 5
+	push constant 2
+	or
+	pop local 1           // 7
+	push local 1
+	push that 7
+	lt
+	pop static 1          // true
+	push local 1
+	push that 7
+	gt
+	pop static 2          // false
+	push local 1
+	push local 1
+	eq
+	pop static 3          // true
+	push constant 3
+	call Sys.double 1
+	pop static 4          // 6
+	push constant 0
+	pop local 0
+label LOOP
+	push local 0
+	push constant 10
+	lt
+	not
+	if-goto END
+	push local 0
+	push constant 1
+	add
+	pop local 0
+	goto LOOP
+label END
+	push local 0
+	pop static 5          // 10
+	push constant 5       // not 5 is nonzero, so the jump is taken
+	not
+	if-goto NOT_TRUE
+	push constant 1
+	pop static 6
+label NOT_TRUE
+	push constant 9
+	push constant 9
+	eq
+	if-goto EQ_TRUE
+	push constant 1
+	pop static 7
+label EQ_TRUE
+label HALT
+	goto HALT
+
+function Sys.double 2
+	push argument 0
+	push argument 0
+	add
+	return
+`
+
+func TestOptimizedCodeBehavesAsPlainCode(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{"Sys.vm": optimizerTestSys})
+
+	plainSrc := translateFiles(t, nil, paths...)
+	optimizedSrc := translateFiles(t, optimized, paths...)
+	plain := runHack(t, plainSrc, 100000)
+	opt := runHack(t, optimizedSrc, 100000)
+
+	wants := map[int]int16{16: 15, 17: -1, 18: 0, 19: -1, 20: 6, 21: 10, 22: 0, 23: 0, 3005: 99, 3017: 15, 7: 32}
+	for addr, want := range wants {
+		if got := int16(plain.RAM[addr]); got != want {
+			t.Errorf("plain: want RAM[%d] to be %d, but got %d", addr, want, got)
+		}
+	}
+	compareRAM(t, plain, opt)
+
+	if opt.Cycles >= plain.Cycles {
+		t.Errorf("optimized code is not faster: %d >= %d cycles", opt.Cycles, plain.Cycles)
+	}
+}
+
+func TestOptimizedCodePassesProjectTests(t *testing.T) {
+	for _, dir := range []string{
+		"../../projects/08/FunctionCalls/FibonacciElement",
+		"../../projects/08/FunctionCalls/StaticsTest",
+		"../../projects/08/FunctionCalls/NestedCall",
+	} {
+		name := filepath.Base(dir)
+		t.Run(name, func(t *testing.T) {
+			paths := vmFilesIn(t, dir)
+			plain := runTst(t, translateFiles(t, nil, paths...), filepath.Join(dir, name+".tst"))
+			opt := runTst(t, translateFiles(t, optimized, paths...), filepath.Join(dir, name+".tst"))
+			checkCmp(t, plain, filepath.Join(dir, name+".cmp"))
+			checkCmp(t, opt, filepath.Join(dir, name+".cmp"))
+		})
+	}
+}
+
+func TestOptimizedCodeIsSmaller(t *testing.T) {
+	paths := vmFilesIn(t, "../../projects/09/hilow")
+	plain := romSize(t, translateFiles(t, nil, paths...))
+	opt := romSize(t, translateFiles(t, optimized, paths...))
+	t.Logf("ROM size: %d -> %d words", plain, opt)
+	if opt > plain*3/4 {
+		t.Errorf("want at least 25%% reduction, but got %d -> %d words", plain, opt)
+	}
+}
+
+// compareRAM compares registers, statics and the stack of halted programs.
+// R13-R15 and RAM above SP are scratch space which may differ, and RAM[256]
+// holds the return address of the bootstrap call.
+func compareRAM(t *testing.T, want, got *emulator.CPU) {
+	t.Helper()
+	var addrs []int
+	for addr := 0; addr <= 12; addr++ {
+		addrs = append(addrs, addr)
+	}
+	for addr := 16; addr < 256; addr++ {
+		addrs = append(addrs, addr)
+	}
+	for addr := 257; addr < int(want.RAM[0]); addr++ {
+		addrs = append(addrs, addr)
+	}
+	for addr := 2048; addr < 4096; addr++ {
+		addrs = append(addrs, addr)
+	}
+	for _, addr := range addrs {
+		if want.RAM[addr] != got.RAM[addr] {
+			t.Errorf("RAM[%d] differs: want %d, but got %d", addr, int16(want.RAM[addr]), int16(got.RAM[addr]))
+		}
+	}
+}
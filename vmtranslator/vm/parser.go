@@ -0,0 +1,257 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+type CommandType int
+
+const (
+	C_ARITHMETIC CommandType = iota
+	C_PUSH
+	C_POP
+	C_LABEL
+	C_GOTO
+	C_IF
+	C_FUNCTION
+	C_RETURN
+	C_CALL
+)
+
+// Command is a parsed VM command.
+type Command struct {
+	Type CommandType
+	Arg1 string
+	Arg2 int
+	// Line is the 1-based line number of the command, or 0 if unknown.
+	Line int
+}
+
+func (c Command) String() string {
+	switch c.Type {
+	case C_ARITHMETIC:
+		return c.Arg1
+	case C_PUSH:
+		return fmt.Sprintf("push %s %d", c.Arg1, c.Arg2)
+	case C_POP:
+		return fmt.Sprintf("pop %s %d", c.Arg1, c.Arg2)
+	case C_LABEL:
+		return "label " + c.Arg1
+	case C_GOTO:
+		return "goto " + c.Arg1
+	case C_IF:
+		return "if-goto " + c.Arg1
+	case C_FUNCTION:
+		return fmt.Sprintf("function %s %d", c.Arg1, c.Arg2)
+	case C_RETURN:
+		return "return"
+	case C_CALL:
+		return fmt.Sprintf("call %s %d", c.Arg1, c.Arg2)
+	default:
+		return fmt.Sprintf("unknown command type %d", c.Type)
+	}
+}
+
+type Parser struct {
+	scanner        *bufio.Scanner
+	currentCommand []string
+	nextCommand    []string
+	isEOF          bool
+	scannedLines   int
+	currentLine    int
+	nextLine       int
+	err            error
+	extended       bool
+}
+
+func NewParser(in io.Reader) *Parser {
+	scanner := bufio.NewScanner(in)
+	parser := Parser{scanner: scanner}
+	parser.Advance() // load nextCommand
+	return &parser
+}
+
+// SetExtended enables the extended commands. See extendedCommands.
+func (p *Parser) SetExtended(extended bool) {
+	p.extended = extended
+}
+
+func (p *Parser) HasMoreCommands() bool {
+	return !p.isEOF
+}
+
+func (p *Parser) Advance() {
+	p.currentCommand = p.nextCommand
+	p.currentLine = p.nextLine
+	for p.scanner.Scan() {
+		p.scannedLines++
+		line := p.scanner.Text()
+		line = trimComment(line)
+
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		p.nextCommand = fields
+		p.nextLine = p.scannedLines
+		return
+	}
+	p.nextCommand = nil
+	p.isEOF = true
+	if err := p.scanner.Err(); err != nil && p.err == nil {
+		p.err = err
+	}
+}
+
+func trimComment(line string) string {
+	if i := strings.Index(line, "//"); i >= 0 {
+		return line[:i]
+	}
+	return line
+}
+
+// Line returns the 1-based line number of the current command.
+func (p *Parser) Line() int {
+	return p.currentLine
+}
+
+// Err returns the first error found by the parser. Methods returning
+// the current command return zero values after an error.
+func (p *Parser) Err() error {
+	return p.err
+}
+
+func (p *Parser) fail(format string, args ...any) {
+	if p.err == nil {
+		p.err = &Error{Line: p.currentLine, Message: fmt.Sprintf(format, args...)}
+	}
+}
+
+// Fields returns the words of the current command.
+func (p *Parser) Fields() []string {
+	return p.currentCommand
+}
+
+var commandTypes = map[string]CommandType{
+	"add":      C_ARITHMETIC,
+	"sub":      C_ARITHMETIC,
+	"neg":      C_ARITHMETIC,
+	"eq":       C_ARITHMETIC,
+	"gt":       C_ARITHMETIC,
+	"lt":       C_ARITHMETIC,
+	"and":      C_ARITHMETIC,
+	"or":       C_ARITHMETIC,
+	"not":      C_ARITHMETIC,
+	"push":     C_PUSH,
+	"pop":      C_POP,
+	"label":    C_LABEL,
+	"goto":     C_GOTO,
+	"if-goto":  C_IF,
+	"function": C_FUNCTION,
+	"return":   C_RETURN,
+	"call":     C_CALL,
+}
+
+// extendedCommands are arithmetic commands of the extended VM dialect.
+//
+//	mul, div       x * y, x / y rounded toward zero (x / 0 is 0)
+//	shl, shr       x << y, x >> y with sign extension, for y in 0-15
+//	le, ge, ne     x <= y, x >= y, x != y
+//	dup            push a copy of y
+//	swap           exchange x and y
+var extendedCommands = map[string]bool{
+	"mul":  true,
+	"div":  true,
+	"shl":  true,
+	"shr":  true,
+	"le":   true,
+	"ge":   true,
+	"ne":   true,
+	"dup":  true,
+	"swap": true,
+}
+
+// lookupCommand returns the type of command, which may be extended.
+func lookupCommand(command string, extended bool) (CommandType, bool) {
+	if extended && extendedCommands[command] {
+		return C_ARITHMETIC, true
+	}
+	typ, ok := commandTypes[command]
+	return typ, ok
+}
+
+// CommandType returns the type of the current command, or -1 if it is unknown.
+func (p *Parser) CommandType() CommandType {
+	typ, ok := lookupCommand(p.currentCommand[0], p.extended)
+	if !ok {
+		p.fail("unknown command %s", p.currentCommand[0])
+		return -1
+	}
+	return typ
+}
+
+// field returns the i-th word of the current command.
+func (p *Parser) field(i int) (string, bool) {
+	if i >= len(p.currentCommand) {
+		p.fail("%s takes more than %d arguments", p.currentCommand[0], len(p.currentCommand)-1)
+		return "", false
+	}
+	return p.currentCommand[i], true
+}
+
+func (p *Parser) Arg1() string {
+	commandType := p.CommandType()
+	if commandType < 0 {
+		return ""
+	}
+	if commandType == C_RETURN {
+		panic("vm: Arg1 called for return")
+	}
+
+	if commandType == C_ARITHMETIC {
+		return p.currentCommand[0]
+	}
+	arg, _ := p.field(1)
+	return arg
+}
+
+func (p *Parser) Arg2() int {
+	commandType := p.CommandType()
+	if commandType < 0 {
+		return 0
+	}
+	if commandType != C_PUSH &&
+		commandType != C_POP &&
+		commandType != C_FUNCTION &&
+		commandType != C_CALL {
+		panic("vm: Arg2 called for " + p.currentCommand[0])
+	}
+
+	arg, ok := p.field(2)
+	if !ok {
+		return 0
+	}
+	i, err := strconv.Atoi(arg)
+	if err != nil {
+		p.fail("second argument of %s must be an integer, but got %s", p.currentCommand[0], arg)
+	}
+	return i
+}
+
+// Command returns the current command.
+func (p *Parser) Command() Command {
+	cmd := Command{Type: p.CommandType(), Line: p.currentLine}
+	switch cmd.Type {
+	case -1, C_RETURN:
+	case C_PUSH, C_POP, C_FUNCTION, C_CALL:
+		cmd.Arg1 = p.Arg1()
+		cmd.Arg2 = p.Arg2()
+	default:
+		cmd.Arg1 = p.Arg1()
+	}
+	return cmd
+}
@@ -1,6 +1,7 @@
-package main
+package vm
 
 import (
+	"errors"
 	"strings"
 	"testing"
 )
@@ -48,3 +49,19 @@ add
 		t.Fatal("HasMoreCommands(4) returned true, but there should be no commands left")
 	}
 }
+
+func TestParserErr(t *testing.T) {
+	p := NewParser(strings.NewReader("push constant 1\n\nmul\n"))
+	var cmds []Command
+	for p.HasMoreCommands() {
+		p.Advance()
+		cmds = append(cmds, p.Command())
+	}
+	if cmds[0] != (Command{Type: C_PUSH, Arg1: "constant", Arg2: 1, Line: 1}) {
+		t.Errorf("got %+v", cmds[0])
+	}
+	var e *Error
+	if !errors.As(p.Err(), &e) || e.Line != 3 || e.Message != "unknown command mul" {
+		t.Errorf("want unknown command at line 3, got %v", p.Err())
+	}
+}
@@ -0,0 +1,194 @@
+package vm
+
+import (
+	"bytes"
+	"cmp"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// entryFunction is the function called by the bootstrap code.
+const entryFunction = "Sys.init"
+
+// Program is a set of VM files translated together.
+type Program struct {
+	Files []*VMFile
+}
+
+// VMFile is a parsed VM file.
+type VMFile struct {
+	// Name is the base name of the file, which qualifies statics and labels.
+	Name     string
+	Commands []Command
+}
+
+// CompareFileNames orders VM files for translation: Sys.vm first so that
+// the bootstrap code is followed by Sys.init, then by name.
+func CompareFileNames(a, b string) int {
+	if (a == "Sys.vm") != (b == "Sys.vm") {
+		if a == "Sys.vm" {
+			return -1
+		}
+		return 1
+	}
+	return cmp.Compare(a, b)
+}
+
+// source is the content of a VM file.
+type source struct {
+	name string
+	data []byte
+}
+
+// readSources reads files in the order of CompareFileNames.
+func readSources(files map[string]io.Reader) ([]source, error) {
+	var srcs []source
+	for name, r := range files {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		srcs = append(srcs, source{name, data})
+	}
+	slices.SortFunc(srcs, func(a, b source) int {
+		return CompareFileNames(a.name, b.name)
+	})
+	return srcs, nil
+}
+
+// ParseProgram validates and parses VM files named by their base names.
+// Invalid programs are reported by an ErrorList. If extended is true,
+// the extended commands are allowed.
+func ParseProgram(files map[string]io.Reader, extended bool) (*Program, error) {
+	srcs, err := readSources(files)
+	if err != nil {
+		return nil, err
+	}
+	if errs := validate(srcs, false, extended); len(errs) > 0 {
+		return nil, errs
+	}
+
+	prog := &Program{}
+	for _, src := range srcs {
+		file := &VMFile{Name: src.name}
+		p := NewParser(bytes.NewReader(src.data))
+		p.SetExtended(extended)
+		for p.HasMoreCommands() {
+			p.Advance()
+			file.Commands = append(file.Commands, p.Command())
+		}
+		if err := p.Err(); err != nil {
+			var e *Error
+			if errors.As(err, &e) {
+				e.File = src.name
+			}
+			return nil, err
+		}
+		prog.Files = append(prog.Files, file)
+	}
+	return prog, nil
+}
+
+// LoadProgram validates and parses VM files at paths. See ParseProgram.
+func LoadProgram(paths []string, extended bool) (*Program, error) {
+	files := make(map[string]io.Reader)
+	for _, path := range paths {
+		name := filepath.Base(path)
+		if _, ok := files[name]; ok {
+			return nil, fmt.Errorf("more than one file is named %s", name)
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer in.Close()
+		files[name] = in
+	}
+	return ParseProgram(files, extended)
+}
+
+// Defines reports whether funcName is defined in the program.
+func (p *Program) Defines(funcName string) bool {
+	for _, file := range p.Files {
+		for _, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION && cmd.Arg1 == funcName {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// RemoveUnreachable removes functions which cannot be called from the
+// code running first or from commands outside functions, and returns their
+// names in program order. The code running first is Sys.init with bootstrap
+// code, and otherwise the function the program starts with, if any.
+func (p *Program) RemoveUnreachable(bootstrap bool) []string {
+	callees := make(map[string][]string)
+	var roots []string
+	if bootstrap {
+		roots = append(roots, entryFunction)
+	} else if first, ok := p.firstCommand(); ok && first.Type == C_FUNCTION {
+		roots = append(roots, first.Arg1)
+	}
+	for _, file := range p.Files {
+		function := ""
+		for _, cmd := range file.Commands {
+			switch cmd.Type {
+			case C_FUNCTION:
+				function = cmd.Arg1
+			case C_CALL:
+				if function == "" {
+					roots = append(roots, cmd.Arg1)
+				} else {
+					callees[function] = append(callees[function], cmd.Arg1)
+				}
+			}
+		}
+	}
+
+	reachable := make(map[string]bool)
+	queue := roots
+	for len(queue) > 0 {
+		function := queue[0]
+		queue = queue[1:]
+		if reachable[function] {
+			continue
+		}
+		reachable[function] = true
+		queue = append(queue, callees[function]...)
+	}
+
+	var removed []string
+	for _, file := range p.Files {
+		var kept []Command
+		keep := true
+		for _, cmd := range file.Commands {
+			if cmd.Type == C_FUNCTION {
+				keep = reachable[cmd.Arg1]
+				if !keep {
+					removed = append(removed, cmd.Arg1)
+				}
+			}
+			if keep {
+				kept = append(kept, cmd)
+			}
+		}
+		file.Commands = kept
+	}
+	return removed
+}
+
+// firstCommand returns the command which runs first without bootstrap code.
+func (p *Program) firstCommand() (Command, bool) {
+	for _, file := range p.Files {
+		if len(file.Commands) > 0 {
+			return file.Commands[0], true
+		}
+	}
+	return Command{}, false
+}
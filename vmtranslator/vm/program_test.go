@@ -0,0 +1,202 @@
+package vm
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+var projectTestDirs = []string{
+	"../../projects/07/StackArithmetic/SimpleAdd",
+	"../../projects/07/StackArithmetic/StackTest",
+	"../../projects/07/MemoryAccess/BasicTest",
+	"../../projects/07/MemoryAccess/PointerTest",
+	"../../projects/07/MemoryAccess/StaticTest",
+	"../../projects/08/ProgramFlow/BasicLoop",
+	"../../projects/08/ProgramFlow/FibonacciSeries",
+	"../../projects/08/FunctionCalls/SimpleFunction",
+	"../../projects/08/FunctionCalls/NestedCall",
+	"../../projects/08/FunctionCalls/FibonacciElement",
+	"../../projects/08/FunctionCalls/StaticsTest",
+}
+
+// TestProjects runs the course's test scripts of projects 07 and 08,
+// which expect bootstrap code only for programs with Sys.init.
+func TestProjects(t *testing.T) {
+	modes := map[string]func(*CodeWriter){
+		"plain":             nil,
+		"optimized":         optimized,
+		"compact":           compacted,
+		"optimized,compact": optimizedAndCompacted,
+		"checked":           checked,
+		"optimized,compact,checked": func(w *CodeWriter) {
+			optimizedAndCompacted(w)
+			checked(w)
+		},
+		"tos":           cachedTop,
+		"optimized,tos": optimizedAndCachedTop,
+		"tco": func(w *CodeWriter) {
+			w.SetTailCalls(AllTailCalls)
+		},
+		"optimized,compact,tos,checked": func(w *CodeWriter) {
+			optimizedAndCompacted(w)
+			cachedTop(w)
+			checked(w)
+		},
+	}
+	for _, dir := range projectTestDirs {
+		name := filepath.Base(dir)
+		for mode, configure := range modes {
+			t.Run(name+"/"+mode, func(t *testing.T) {
+				src := translateFiles(t, configure, vmFilesIn(t, dir)...)
+				cpu := runTst(t, src, filepath.Join(dir, name+".tst"))
+				checkCmp(t, cpu, filepath.Join(dir, name+".cmp"))
+			})
+		}
+	}
+}
+
+func TestProgramDefines(t *testing.T) {
+	prog, err := LoadProgram(vmFilesIn(t, "../../projects/08/FunctionCalls/FibonacciElement"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !prog.Defines("Sys.init") || !prog.Defines("Main.fibonacci") {
+		t.Error("want Sys.init and Main.fibonacci to be defined")
+	}
+	if prog.Defines("Main.main") {
+		t.Error("want Main.main not to be defined")
+	}
+}
+
+func TestRemoveUnreachable(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{
+		"Sys.vm": `
+function Sys.init 0
+call Main.main 0
+label END
+goto END
+`,
+		"Main.vm": `
+function Main.unused 0
+call Main.helper 0
+return
+function Main.main 0
+push constant 3
+call Main.countdown 1
+return
+function Main.countdown 0
+push argument 0
+if-goto RECURSE
+push constant 0
+return
+label RECURSE
+push argument 0
+push constant 1
+sub
+call Main.countdown 1
+return
+function Main.helper 0
+push constant 0
+return
+`,
+	})
+	prog, err := LoadProgram(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := prog.RemoveUnreachable(true)
+	if want := []string{"Main.unused", "Main.helper"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v", removed, want)
+	}
+	for _, funcName := range []string{"Sys.init", "Main.main", "Main.countdown"} {
+		if !prog.Defines(funcName) {
+			t.Errorf("%s is removed", funcName)
+		}
+	}
+}
+
+// TestRemoveUnreachableWithoutBootstrap keeps the function which runs
+// first as the program starts with it, as SimpleFunction does.
+func TestRemoveUnreachableWithoutBootstrap(t *testing.T) {
+	dir := "../../projects/08/FunctionCalls/SimpleFunction"
+	prog, err := LoadProgram(vmFilesIn(t, dir), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if removed := prog.RemoveUnreachable(false); len(removed) > 0 {
+		t.Errorf("want nothing removed, but removed %v", removed)
+	}
+	var buf bytes.Buffer
+	if err := TranslateProgram(prog, NewCodeWriter(&buf), false); err != nil {
+		t.Fatal(err)
+	}
+	cpu := runTst(t, buf.String(), filepath.Join(dir, "SimpleFunction.tst"))
+	checkCmp(t, cpu, filepath.Join(dir, "SimpleFunction.cmp"))
+
+	paths := writeVMFiles(t, map[string]string{
+		"Main.vm": "function Main.main 0\ncall Main.used 0\nreturn\nfunction Main.used 0\npush constant 0\nreturn\nfunction Main.unused 0\npush constant 0\nreturn\n",
+		"Util.vm": "function Util.f 0\ncall Main.unused 0\nreturn\n",
+	})
+	prog, err = LoadProgram(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	removed := prog.RemoveUnreachable(false)
+	if want := []string{"Main.unused", "Util.f"}; !reflect.DeepEqual(removed, want) {
+		t.Errorf("removed %v, want %v without bootstrap", removed, want)
+	}
+}
+
+// multiplyMain stores 6 * -7 in RAM[6] using the OS.
+const multiplyMain = `
+function Main.main 0
+push constant 6
+push constant 7
+neg
+call Math.multiply 2
+pop temp 1
+label END
+goto END
+`
+
+// TestProgramWithOS runs a program linked with the OS, which only fits into
+// ROM when optimized and compacted or once unreachable functions are removed.
+func TestProgramWithOS(t *testing.T) {
+	paths := append(vmFilesIn(t, "../../tools/OS"), writeVMFiles(t, map[string]string{"Main.vm": multiplyMain})...)
+	prog, err := LoadProgram(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check := func(t *testing.T, w *CodeWriter, buf *bytes.Buffer) {
+		if err := TranslateProgram(prog, w, true); err != nil {
+			t.Fatal(err)
+		}
+		cpu := runHack(t, buf.String(), 10_000_000)
+		if got := int16(cpu.RAM[6]); got != -42 {
+			t.Errorf("RAM[6] = %d, want -42", got)
+		}
+	}
+
+	t.Run("optimized,compact", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewCodeWriter(&buf)
+		optimizedAndCompacted(w)
+		check(t, w, &buf)
+	})
+	t.Run("optimized,compact,tos", func(t *testing.T) {
+		var buf bytes.Buffer
+		w := NewCodeWriter(&buf)
+		optimizedAndCompacted(w)
+		cachedTop(w)
+		check(t, w, &buf)
+	})
+	t.Run("unreachable removed", func(t *testing.T) {
+		if len(prog.RemoveUnreachable(true)) == 0 {
+			t.Fatal("want unreachable OS functions to be removed")
+		}
+		var buf bytes.Buffer
+		check(t, NewCodeWriter(&buf), &buf)
+	})
+}
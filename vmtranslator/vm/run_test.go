@@ -0,0 +1,163 @@
+package vm
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+
+	"assembler/asm"
+	"assembler/emulator"
+)
+
+// This file has helpers to run translated programs on the Hack emulator.
+
+// writeVMFiles writes VM sources into a temporary directory
+// and returns their paths sorted by name.
+func writeVMFiles(t *testing.T, sources map[string]string) []string {
+	t.Helper()
+	dir := t.TempDir()
+	for name, src := range sources {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return vmFilesIn(t, dir)
+}
+
+func vmFilesIn(t *testing.T, dir string) []string {
+	t.Helper()
+	paths, err := filepath.Glob(filepath.Join(dir, "*.vm"))
+	if err != nil || len(paths) == 0 {
+		t.Fatalf("no vm files in %s: %v", dir, err)
+	}
+	return paths
+}
+
+// readVMFiles reads files into readers named by their base names.
+func readVMFiles(t *testing.T, paths []string) map[string]io.Reader {
+	t.Helper()
+	files := make(map[string]io.Reader)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[filepath.Base(path)] = bytes.NewReader(data)
+	}
+	return files
+}
+
+// translateFiles translates VM files as main does.
+func translateFiles(t *testing.T, configure func(*CodeWriter), paths ...string) string {
+	t.Helper()
+	prog, err := LoadProgram(paths, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	w := NewCodeWriter(&buf)
+	if configure != nil {
+		configure(w)
+	}
+	TranslateProgram(prog, w, prog.Defines("Sys.init"))
+	return buf.String()
+}
+
+func optimized(w *CodeWriter) {
+	w.SetOptimize(true)
+}
+
+func assemble(t *testing.T, src string) []uint16 {
+	t.Helper()
+	program, _, err := asm.Assemble(strings.NewReader(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return program
+}
+
+// runHack runs Hack assembly until it halts.
+func runHack(t *testing.T, src string, maxCycles uint64) *emulator.CPU {
+	t.Helper()
+	cpu := emulator.New(assemble(t, src))
+	if !cpu.Run(maxCycles) {
+		t.Fatalf("program did not halt in %d cycles: PC=%d", maxCycles, cpu.PC)
+	}
+	return cpu
+}
+
+var (
+	tstSetPattern    = regexp.MustCompile(`set RAM\[(\d+)\] (-?\d+)`)
+	tstRepeatPattern = regexp.MustCompile(`repeat (\d+)`)
+	cmpHeaderPattern = regexp.MustCompile(`RAM\[(\d+)`)
+)
+
+// runTst runs Hack assembly as a course test script (.tst) does.
+func runTst(t *testing.T, src, tstPath string) *emulator.CPU {
+	t.Helper()
+	cpu, cycles := loadTst(t, src, tstPath)
+	cpu.Run(cycles)
+	return cpu
+}
+
+// loadTst loads Hack assembly with RAM set as a course test script does,
+// and returns the number of cycles the script runs.
+func loadTst(t *testing.T, src, tstPath string) (*emulator.CPU, uint64) {
+	t.Helper()
+	tst, err := os.ReadFile(tstPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpu := emulator.New(assemble(t, src))
+	for _, m := range tstSetPattern.FindAllStringSubmatch(string(tst), -1) {
+		addr, _ := strconv.Atoi(m[1])
+		value, _ := strconv.Atoi(m[2])
+		cpu.RAM[addr] = uint16(value)
+	}
+	m := tstRepeatPattern.FindStringSubmatch(string(tst))
+	if m == nil {
+		t.Fatalf("no repeat in %s", tstPath)
+	}
+	cycles, _ := strconv.ParseUint(m[1], 10, 64)
+	return cpu, cycles
+}
+
+// checkCmp compares RAM with the expected values in a course compare file.
+func checkCmp(t *testing.T, cpu *emulator.CPU, cmpPath string) {
+	t.Helper()
+	for addr, want := range readCmp(t, cmpPath) {
+		if got := int16(cpu.RAM[addr]); got != want {
+			t.Errorf("want RAM[%d] to be %d, but got %d", addr, want, got)
+		}
+	}
+}
+
+// readCmp returns the RAM values of a course compare file.
+func readCmp(t *testing.T, cmpPath string) map[int]int16 {
+	t.Helper()
+	cmp, err := os.ReadFile(cmpPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(cmp)), "\n")
+	addrs := cmpHeaderPattern.FindAllStringSubmatch(lines[0], -1)
+	values := strings.FieldsFunc(lines[1], func(r rune) bool { return r == '|' || r == ' ' })
+	ram := make(map[int]int16)
+	for i, m := range addrs {
+		addr, _ := strconv.Atoi(m[1])
+		value, _ := strconv.Atoi(values[i])
+		ram[addr] = int16(value)
+	}
+	return ram
+}
+
+// romSize counts instructions in Hack assembly.
+func romSize(t *testing.T, src string) int {
+	t.Helper()
+	return len(assemble(t, src))
+}
@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"cmp"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ROMWords is the size of the Hack ROM.
+const ROMWords = 32768
+
+const bootstrapName = "(bootstrap)"
+
+// CodeSize is the number of instructions written for a function. Code
+// outside functions has an empty Function, and the bootstrap code and
+// shared routines have an empty File as well.
+type CodeSize struct {
+	File         string
+	Function     string
+	Instructions int
+}
+
+func (s CodeSize) name() string {
+	switch {
+	case s.File == "":
+		return bootstrapName
+	case s.Function == "":
+		return s.File + " (outside functions)"
+	default:
+		return s.Function
+	}
+}
+
+func (w *CodeWriter) countInstruction() {
+	key := CodeSize{File: w.currentFile, Function: w.currentFunction}
+	if key.File == "" {
+		key.Function = ""
+	}
+	i, ok := w.sizeIndex[key]
+	if !ok {
+		i = len(w.sizes)
+		w.sizeIndex[key] = i
+		w.sizes = append(w.sizes, key)
+	}
+	w.sizes[i].Instructions++
+}
+
+// Sizes returns the number of instructions written so far per function
+// in the order they were written.
+func (w *CodeWriter) Sizes() []CodeSize {
+	return slices.Clone(w.sizes)
+}
+
+// WriteSizeReport writes instructions per function and per file, largest
+// first, and returns the total.
+func WriteSizeReport(out io.Writer, sizes []CodeSize) int {
+	byFunction := slices.Clone(sizes)
+	var byFile []CodeSize
+	total := 0
+	for _, s := range sizes {
+		total += s.Instructions
+		i := slices.IndexFunc(byFile, func(f CodeSize) bool { return f.File == s.File })
+		if i < 0 {
+			byFile = append(byFile, CodeSize{File: s.File})
+			i = len(byFile) - 1
+		}
+		byFile[i].Instructions += s.Instructions
+	}
+	descending := func(a, b CodeSize) int {
+		return cmp.Compare(b.Instructions, a.Instructions)
+	}
+	slices.SortStableFunc(byFunction, descending)
+	slices.SortStableFunc(byFile, descending)
+
+	fmt.Fprintln(out, "instructions per function:")
+	for _, s := range byFunction {
+		fmt.Fprintf(out, "%7d  %s\n", s.Instructions, s.name())
+	}
+	fmt.Fprintln(out, "instructions per file:")
+	for _, s := range byFile {
+		name := s.File
+		if name == "" {
+			name = bootstrapName
+		}
+		fmt.Fprintf(out, "%7d  %s\n", s.Instructions, name)
+	}
+	fmt.Fprintf(out, "total: %d of %d words\n", total, ROMWords)
+	return total
+}
@@ -0,0 +1,68 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSizes(t *testing.T) {
+	prog, err := LoadProgram(vmFilesIn(t, "../../projects/08/FunctionCalls/FibonacciElement"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mode, configure := range map[string]func(*CodeWriter){
+		"plain":             nil,
+		"optimized,compact": optimizedAndCompacted,
+	} {
+		t.Run(mode, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewCodeWriter(&buf)
+			if configure != nil {
+				configure(w)
+			}
+			TranslateProgram(prog, w, true)
+
+			names := make(map[string]int)
+			total := 0
+			for _, s := range w.Sizes() {
+				names[s.name()] = s.Instructions
+				total += s.Instructions
+			}
+			if want := len(assemble(t, buf.String())); total != want {
+				t.Errorf("total size is %d, want %d", total, want)
+			}
+			for _, name := range []string{bootstrapName, "Main.fibonacci", "Sys.init"} {
+				if names[name] == 0 {
+					t.Errorf("no instructions counted for %s in %v", name, names)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteSizeReport(t *testing.T) {
+	var buf bytes.Buffer
+	total := WriteSizeReport(&buf, []CodeSize{
+		{"", "", 4},
+		{"Main.vm", "Main.small", 10},
+		{"Main.vm", "Main.large", 30},
+		{"Sys.vm", "Sys.init", 20},
+	})
+	if total != 64 {
+		t.Errorf("total = %d, want 64", total)
+	}
+	want := `instructions per function:
+     30  Main.large
+     20  Sys.init
+     10  Main.small
+      4  (bootstrap)
+instructions per file:
+     40  Main.vm
+     20  Sys.vm
+      4  (bootstrap)
+total: 64 of 32768 words
+`
+	if got := buf.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,103 @@
+package vm
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// SourceLocation is the VM command an instruction was written for.
+// Fused command sequences have the line of their first command. The
+// bootstrap code and shared routines have an empty File and Function.
+type SourceLocation struct {
+	File     string
+	Line     int
+	Function string
+}
+
+func (w *CodeWriter) recordLocation() {
+	loc := SourceLocation{w.currentFile, w.currentLine, w.currentFunction}
+	if loc.File == "" {
+		loc = SourceLocation{}
+	}
+	w.locations = append(w.locations, loc)
+}
+
+// SourceMap returns the location of each instruction written so far,
+// indexed by its ROM address.
+func (w *CodeWriter) SourceMap() []SourceLocation {
+	return slices.Clone(w.locations)
+}
+
+// sourceMapEmpty stands for empty fields in source maps.
+const sourceMapEmpty = "-"
+
+// WriteSourceMap writes a source map as text. Each line maps a range of ROM
+// addresses to a location:
+//
+//	FIRST LAST FILE LINE FUNCTION
+//
+// Empty fields are written as "-".
+func WriteSourceMap(out io.Writer, locs []SourceLocation) error {
+	bw := bufio.NewWriter(out)
+	field := func(s string) string {
+		if s == "" {
+			return sourceMapEmpty
+		}
+		return s
+	}
+	for first := 0; first < len(locs); {
+		last := first
+		for last+1 < len(locs) && locs[last+1] == locs[first] {
+			last++
+		}
+		loc := locs[first]
+		fmt.Fprintf(bw, "%d %d %s %d %s\n", first, last, field(loc.File), loc.Line, field(loc.Function))
+		first = last + 1
+	}
+	return bw.Flush()
+}
+
+// ReadSourceMap reads a source map written by WriteSourceMap.
+func ReadSourceMap(in io.Reader) ([]SourceLocation, error) {
+	var locs []SourceLocation
+	scanner := bufio.NewScanner(in)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if len(fields) != 5 {
+			return nil, fmt.Errorf("line %d: want 5 fields, but got %d", lineNum, len(fields))
+		}
+		var nums [3]int
+		for i, s := range []string{fields[0], fields[1], fields[3]} {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("line %d: invalid number %q", lineNum, s)
+			}
+			nums[i] = n
+		}
+		first, last, line := nums[0], nums[1], nums[2]
+		if first != len(locs) || last < first {
+			return nil, fmt.Errorf("line %d: addresses %d-%d don't follow %d", lineNum, first, last, len(locs)-1)
+		}
+		loc := SourceLocation{File: fields[2], Line: line, Function: fields[4]}
+		if loc.File == sourceMapEmpty {
+			loc.File = ""
+		}
+		if loc.Function == sourceMapEmpty {
+			loc.Function = ""
+		}
+		for ; first <= last; first++ {
+			locs = append(locs, loc)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return locs, nil
+}
@@ -0,0 +1,84 @@
+package vm
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"assembler/asm"
+)
+
+func TestSourceMap(t *testing.T) {
+	prog, err := LoadProgram(vmFilesIn(t, "../../projects/08/FunctionCalls/FibonacciElement"), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for mode, configure := range map[string]func(*CodeWriter){
+		"plain":             nil,
+		"optimized,compact": optimizedAndCompacted,
+	} {
+		t.Run(mode, func(t *testing.T) {
+			var buf bytes.Buffer
+			w := NewCodeWriter(&buf)
+			if configure != nil {
+				configure(w)
+			}
+			if err := TranslateProgram(prog, w, true); err != nil {
+				t.Fatal(err)
+			}
+			program, symbols, err := asm.Assemble(strings.NewReader(buf.String()))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			locs := w.SourceMap()
+			if len(locs) != len(program) {
+				t.Fatalf("source map has %d addresses, want %d", len(locs), len(program))
+			}
+			if locs[0] != (SourceLocation{}) {
+				t.Errorf("want bootstrap code at 0, got %+v", locs[0])
+			}
+			addresses := make(map[string]uint16)
+			for _, s := range symbols.Symbols() {
+				addresses[s.Name] = s.Address
+			}
+			for label, want := range map[string]SourceLocation{
+				"Main.fibonacci": {"Main.vm", 11, "Main.fibonacci"},
+				"Sys.init":       {"Sys.vm", 12, "Sys.init"},
+			} {
+				// Optimized functions without locals have no instructions
+				// of their own, so the label is at the first command.
+				got := locs[addresses[label]]
+				if got.File != want.File || got.Function != want.Function || got.Line < want.Line || (configure == nil && got.Line != want.Line) {
+					t.Errorf("location of %s is %+v, want %+v", label, got, want)
+				}
+			}
+
+			var text bytes.Buffer
+			if err := WriteSourceMap(&text, locs); err != nil {
+				t.Fatal(err)
+			}
+			read, err := ReadSourceMap(&text)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(read, locs) {
+				t.Error("source map differs after writing and reading")
+			}
+		})
+	}
+}
+
+func TestReadSourceMapErrors(t *testing.T) {
+	for _, src := range []string{
+		"0 1 Main.vm 3\n",
+		"0 1 Main.vm x Main.main\n",
+		"0 1 - 0 -\n3 4 Main.vm 3 Main.main\n",
+		"2 1 Main.vm 3 Main.main\n",
+	} {
+		if _, err := ReadSourceMap(strings.NewReader(src)); err == nil {
+			t.Errorf("want error for %q", src)
+		}
+	}
+}
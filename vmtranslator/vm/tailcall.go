@@ -0,0 +1,163 @@
+package vm
+
+import "slices"
+
+// This file implements the tail calls enabled by SetTailCalls. A call
+// followed by return jumps to the callee with the frame of the caller
+// replaced, so that recursion in tail position runs in constant stack.
+//
+// The saved frame of the caller is copied above the arguments of the call,
+// which makes the arguments and the frame contiguous as after a call.
+// They are then moved down to ARG, where the arguments of the caller were:
+//
+//	before                   copied                   moved
+//	ARG  -> caller args      ARG  -> caller args      ARG  -> callee args
+//	        saved frame              saved frame              saved frame
+//	LCL  -> locals           LCL  -> locals           LCL, SP
+//	        ...                      ...
+//	        callee args              callee args
+//	SP                               saved frame
+//
+// If the caller was called with as many arguments as the call passes, as
+// self recursion usually is, ARG + nArgs + 5 == LCL and the saved frame is
+// already in place. Only the arguments are then moved down to ARG.
+//
+// The callee returns to the caller of the caller with the return value at
+// ARG, as the return of the caller would.
+
+// TailCallMode selects calls written as tail calls.
+type TailCallMode int
+
+const (
+	// NoTailCalls writes every call as a call.
+	NoTailCalls TailCallMode = iota
+	// SelfTailCalls writes calls of the function itself followed by return
+	// as tail calls.
+	SelfTailCalls
+	// AllTailCalls writes every call followed by return as a tail call.
+	AllTailCalls
+)
+
+// savedFrameSize is the number of words call saves: the return address,
+// LCL, ARG, THIS and THAT.
+const savedFrameSize = 5
+
+// SetTailCalls selects calls followed by return which reuse the frame of
+// the caller.
+func (w *CodeWriter) SetTailCalls(mode TailCallMode) {
+	w.tailCalls = mode
+}
+
+// writeTailCall writes leading `call f n; return` as a tail call.
+// It returns the number of written commands, or 0 if they are not a tail
+// call selected by SetTailCalls.
+func (w *CodeWriter) writeTailCall(cmds []Command) int {
+	if len(cmds) < 2 || cmds[0].Type != C_CALL || cmds[1].Type != C_RETURN || w.currentFunction == "" {
+		return 0
+	}
+	call := cmds[0]
+	switch {
+	case w.tailCalls == SelfTailCalls && call.Arg1 == w.currentFunction:
+	case w.tailCalls == AllTailCalls:
+	default:
+		return 0
+	}
+
+	w.writeComment(cmds[0], cmds[1])
+	frameInPlaceLabel := w.genSequencialLabel("FRAME_IN_PLACE")
+
+	// Keep the frame if LCL - ARG == nArgs + 5.
+	w.writef("@ARG")
+	w.writef("D=M")
+	w.writef("@LCL")
+	w.writef("D=M-D")
+	w.writef("@%d", call.Arg2+savedFrameSize)
+	w.writef("D=D-A")
+	w.writef("@%s", frameInPlaceLabel)
+	w.writef("D;JEQ")
+
+	w.writeStackCheck(savedFrameSize)
+
+	// Copy the frame from LCL - 5 to SP.
+	w.writef("@%d", savedFrameSize)
+	w.writef("D=A")
+	w.writef("@LCL")
+	w.writef("D=M-D")
+	w.writef("@R13")
+	w.writef("M=D")
+	w.writef("@SP")
+	w.writef("D=M")
+	w.writef("@R14")
+	w.writef("M=D")
+	w.writeCopyWords(savedFrameSize)
+
+	// Move the arguments and the frame from SP - nArgs to ARG.
+	w.writeMoveArgs(call.Arg2)
+	w.writeCopyWords(call.Arg2 + savedFrameSize)
+
+	// R14 points next to the frame, where locals of the callee start.
+	w.writef("@R14")
+	w.writef("D=M")
+	w.writef("@LCL")
+	w.writef("M=D")
+	w.writef("@SP")
+	w.writef("M=D")
+	w.writeTailCallJump(call.Arg1)
+
+	// Move only the arguments, and start locals of the callee at LCL.
+	w.writef("(%s)", frameInPlaceLabel)
+	w.writeMoveArgs(call.Arg2)
+	w.writeCopyWords(call.Arg2)
+	w.writef("@LCL")
+	w.writef("D=M")
+	w.writef("@SP")
+	w.writef("M=D")
+	w.writeTailCallJump(call.Arg1)
+	w.writef("// }")
+	w.writef("")
+	return 2
+}
+
+// writeTailCallJump jumps to funcName and records the address of the jump.
+func (w *CodeWriter) writeTailCallJump(funcName string) {
+	w.writef("@%s", funcName)
+	w.tailCallJumps = append(w.tailCallJumps, len(w.locations))
+	w.writef("0;JMP")
+}
+
+// TailCallJumps returns the ROM addresses of the jumps of tail calls
+// written so far, which tell tail calls apart from jumps to labels at the
+// entry of a function.
+func (w *CodeWriter) TailCallJumps() []int {
+	return slices.Clone(w.tailCallJumps)
+}
+
+// writeMoveArgs points R13 to the nArgs arguments below SP and R14 to ARG,
+// where writeCopyWords moves them.
+func (w *CodeWriter) writeMoveArgs(nArgs int) {
+	w.writef("@%d", nArgs)
+	w.writef("D=A")
+	w.writef("@SP")
+	w.writef("D=M-D")
+	w.writef("@R13")
+	w.writef("M=D")
+	w.writef("@ARG")
+	w.writef("D=M")
+	w.writef("@R14")
+	w.writef("M=D")
+}
+
+// writeCopyWords copies n words from where R13 points to where R14 points
+// in ascending order, and leaves them pointing next to the words.
+func (w *CodeWriter) writeCopyWords(n int) {
+	for i := 0; i < n; i++ {
+		w.writef("@R13")
+		w.writef("M=M+1")
+		w.writef("A=M-1")
+		w.writef("D=M")
+		w.writef("@R14")
+		w.writef("M=M+1")
+		w.writef("A=M-1")
+		w.writef("M=D")
+	}
+}
@@ -0,0 +1,138 @@
+package vm
+
+import (
+	"fmt"
+	"testing"
+
+	"assembler/emulator"
+)
+
+// tailCallTestSys computes sum(n, 0) = 1 + ... + n by self recursion and
+// even(n) by mutual recursion of functions taking 1 and 2 arguments.
+const tailCallTestSys = `
+function Sys.init 0
+	push constant %d
+	push constant 0
+	call Sys.sum 2
+	pop static 0
+	push constant %d
+	call Sys.even 1
+	pop static 1
+label HALT
+	goto HALT
+
+function Sys.sum 1
+	push argument 0
+	if-goto RECURSE
+	push argument 1
+	return
+label RECURSE
+	push argument 0
+	push constant 1
+	sub
+	pop local 0
+	push local 0
+	push argument 1
+	push argument 0
+	add
+	call Sys.sum 2
+	return
+
+function Sys.even 0
+	push argument 0
+	if-goto RECURSE
+	push constant 0
+	not
+	return
+label RECURSE
+	push argument 0
+	push constant 1
+	sub
+	push constant 7
+	call Sys.odd 2
+	return
+
+function Sys.odd 2
+	push argument 0
+	if-goto RECURSE
+	push constant 0
+	return
+label RECURSE
+	push argument 0
+	push constant 1
+	sub
+	call Sys.even 1
+	return
+`
+
+// runMaxSP runs Hack assembly until it halts and returns the largest SP.
+func runMaxSP(t *testing.T, src string, maxCycles uint64) (*emulator.CPU, int) {
+	t.Helper()
+	cpu := emulator.New(assemble(t, src))
+	maxSP := 0
+	for !cpu.Halted() {
+		if cpu.Cycles >= maxCycles {
+			t.Fatalf("program did not halt in %d cycles: PC=%d", maxCycles, cpu.PC)
+		}
+		cpu.Step()
+		maxSP = max(maxSP, int(cpu.RAM[0]))
+	}
+	return cpu, maxSP
+}
+
+func TestTailCalls(t *testing.T) {
+	run := func(t *testing.T, configure func(*CodeWriter), nSum, nEven int) int {
+		t.Helper()
+		paths := writeVMFiles(t, map[string]string{"Sys.vm": fmt.Sprintf(tailCallTestSys, nSum, nEven)})
+		cpu, maxSP := runMaxSP(t, translateFiles(t, configure, paths...), 1000000)
+		if got, want := int16(cpu.RAM[16]), int16(nSum*(nSum+1)/2); got != want {
+			t.Errorf("sum(%d) = %d, want %d", nSum, got, want)
+		}
+		if got, want := int16(cpu.RAM[17]), int16(-1+nEven%2); got != want {
+			t.Errorf("even(%d) = %d, want %d", nEven, got, want)
+		}
+		return maxSP
+	}
+
+	modes := map[string]func(*CodeWriter){
+		"plain":     nil,
+		"optimized": optimized,
+		"compact":   optimizedAndCompacted,
+		"tos":       cachedTop,
+		"checked":   checked,
+	}
+	for name, configure := range modes {
+		for tco, mode := range map[string]TailCallMode{"none": NoTailCalls, "self": SelfTailCalls, "all": AllTailCalls} {
+			t.Run(name+"/"+tco, func(t *testing.T) {
+				setup := func(w *CodeWriter) {
+					if configure != nil {
+						configure(w)
+					}
+					w.SetTailCalls(mode)
+				}
+				base := run(t, setup, 3, 3)
+				sumSP := run(t, setup, 100, 3)
+				evenSP := run(t, setup, 3, 100)
+				if constant := mode != NoTailCalls; (sumSP == base) != constant {
+					t.Errorf("SP of self recursion is %d, and %d when it is deeper", base, sumSP)
+				}
+				if constant := mode == AllTailCalls; (evenSP == base) != constant {
+					t.Errorf("SP of mutual recursion is %d, and %d when it is deeper", base, evenSP)
+				}
+			})
+		}
+	}
+}
+
+// TestSelfTailCallsAreFaster checks that self tail calls, which keep the
+// saved frame in place, run faster than calls.
+func TestSelfTailCallsAreFaster(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{"Sys.vm": fmt.Sprintf(tailCallTestSys, 100, 3)})
+	calls, _ := runMaxSP(t, translateFiles(t, nil, paths...), 1000000)
+	tailCalls, _ := runMaxSP(t, translateFiles(t, func(w *CodeWriter) {
+		w.SetTailCalls(SelfTailCalls)
+	}, paths...), 1000000)
+	if tailCalls.Cycles >= calls.Cycles {
+		t.Errorf("self tail calls are not faster: %d >= %d cycles", tailCalls.Cycles, calls.Cycles)
+	}
+}
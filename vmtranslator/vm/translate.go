@@ -0,0 +1,119 @@
+// Package vm translates programs of the Hack virtual machine into Hack assembly.
+package vm
+
+import (
+	"errors"
+	"io"
+)
+
+// errGoOptions reports code generation options which GoWriter doesn't
+// implement.
+var errGoOptions = errors.New("the Go backend cannot be used with Optimize, Compact, CacheTop, TailCalls, Checked or CheckedPointers")
+
+// Options configures Translate.
+type Options struct {
+	// Optimize enables shorter code templates and fusion of common
+	// command sequences. See CodeWriter.SetOptimize.
+	Optimize bool
+	// Compact makes comparisons, calls and returns use shared routines.
+	// See CodeWriter.SetCompact.
+	Compact bool
+	// CacheTop keeps the top of the stack in D within basic blocks.
+	// See CodeWriter.SetCacheTop.
+	CacheTop bool
+	// TailCalls selects calls followed by return which reuse the frame
+	// of the caller. See CodeWriter.SetTailCalls.
+	TailCalls TailCallMode
+	// ExactCompare makes comparisons correct when x - y overflows.
+	// See CodeWriter.SetExactCompare.
+	ExactCompare bool
+	// Extended enables the extended commands. See extendedCommands.
+	Extended bool
+	// Checked makes the stack trap when it grows into the heap.
+	// See CodeWriter.SetChecked.
+	Checked bool
+	// CheckedPointers makes this and that segments trap when they access
+	// outside the heap and the memory maps. See CodeWriter.SetCheckedPointers.
+	CheckedPointers bool
+	// NoBootstrap disables the bootstrap code, which is otherwise
+	// written if Sys.init is defined.
+	NoBootstrap bool
+	// Inline is the size of the largest function to inline in commands,
+	// or 0 to disable inlining. See Program.Inline.
+	Inline int
+	// Go writes a Go program instead of Hack assembly, which can't be
+	// used with other code generation options than ExactCompare and
+	// Extended. See GoWriter.
+	Go bool
+	// RemoveUnreachable removes functions unreachable from the code
+	// running first. See Program.RemoveUnreachable.
+	RemoveUnreachable bool
+}
+
+// Translate translates VM files named by their base names, e.g. Main.vm,
+// into Hack assembly. Invalid programs are reported by an ErrorList.
+func Translate(files map[string]io.Reader, w io.Writer, opts Options) error {
+	if opts.Go && (opts.Optimize || opts.Compact || opts.CacheTop || opts.TailCalls != NoTailCalls || opts.Checked || opts.CheckedPointers) {
+		return errGoOptions
+	}
+	prog, err := ParseProgram(files, opts.Extended)
+	if err != nil {
+		return err
+	}
+	bootstrap, _, _ := prog.Prepare(opts)
+	if opts.Go {
+		goWriter := NewGoWriter(w)
+		goWriter.SetOptions(opts)
+		return goWriter.WriteProgram(prog, bootstrap)
+	}
+	codeWriter := NewCodeWriter(w)
+	codeWriter.SetOptions(opts)
+	return TranslateProgram(prog, codeWriter, bootstrap)
+}
+
+// Prepare inlines functions and removes unreachable functions as opts
+// select. It reports whether to write bootstrap code, and returns the
+// names of inlined and removed functions.
+func (p *Program) Prepare(opts Options) (bootstrap bool, inlined, removed []string) {
+	if opts.Inline > 0 {
+		inlined = p.Inline(opts.Inline)
+	}
+	bootstrap = !opts.NoBootstrap && p.Defines(entryFunction)
+	if opts.RemoveUnreachable {
+		removed = p.RemoveUnreachable(bootstrap)
+	}
+	return bootstrap, inlined, removed
+}
+
+// SetOptions sets the code generation options of opts.
+func (w *CodeWriter) SetOptions(opts Options) {
+	w.SetOptimize(opts.Optimize)
+	w.SetCompact(opts.Compact)
+	w.SetCacheTop(opts.CacheTop)
+	w.SetTailCalls(opts.TailCalls)
+	w.SetExactCompare(opts.ExactCompare)
+	w.SetExtended(opts.Extended)
+	w.SetChecked(opts.Checked)
+	w.SetCheckedPointers(opts.CheckedPointers)
+}
+
+// SetOptions sets the code generation options of opts which apply to Go
+// programs.
+func (w *GoWriter) SetOptions(opts Options) {
+	w.SetExactCompare(opts.ExactCompare)
+}
+
+// TranslateProgram writes the program with bootstrap code if bootstrap is
+// true. Otherwise execution starts at the first command.
+func TranslateProgram(prog *Program, w *CodeWriter, bootstrap bool) error {
+	if bootstrap {
+		w.WriteInit()
+	} else {
+		w.WritePreamble()
+	}
+	for _, file := range prog.Files {
+		w.SetFilename(file.Name)
+		w.WriteCommands(file.Commands)
+	}
+	return w.Err()
+}
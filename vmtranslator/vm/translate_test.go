@@ -0,0 +1,79 @@
+package vm
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+func TestTranslate(t *testing.T) {
+	files := map[string]io.Reader{
+		"Main.vm": strings.NewReader(`function Main.double 0
+push argument 0
+push argument 0
+add
+return
+`),
+		"Sys.vm": strings.NewReader(`function Sys.init 0
+push constant 21
+call Main.double 1
+pop temp 0
+label END
+goto END
+`),
+	}
+	var buf bytes.Buffer
+	if err := Translate(files, &buf, Options{Optimize: true}); err != nil {
+		t.Fatal(err)
+	}
+	cpu := runHack(t, buf.String(), 10000)
+	if got := cpu.RAM[5]; got != 42 {
+		t.Errorf("RAM[5] = %d, want 42", got)
+	}
+}
+
+func TestTranslateErrors(t *testing.T) {
+	files := map[string]io.Reader{
+		"Main.vm": strings.NewReader("push constant 1\npush pointer 2\n"),
+	}
+	err := Translate(files, io.Discard, Options{})
+	var errs ErrorList
+	if !errors.As(err, &errs) || len(errs) != 1 {
+		t.Fatalf("want an ErrorList of one error, got %v", err)
+	}
+	if want := (Error{File: "Main.vm", Line: 2, Message: "index 2 is out of pointer segment (0-1)"}); *errs[0] != want {
+		t.Errorf("got %+v, want %+v", *errs[0], want)
+	}
+}
+
+func TestTranslateGoOptions(t *testing.T) {
+	for _, opts := range []Options{
+		{Go: true, Optimize: true},
+		{Go: true, Compact: true},
+		{Go: true, CacheTop: true},
+		{Go: true, TailCalls: SelfTailCalls},
+		{Go: true, Checked: true},
+		{Go: true, CheckedPointers: true},
+	} {
+		files := map[string]io.Reader{"Main.vm": strings.NewReader("push constant 1\n")}
+		if err := Translate(files, io.Discard, opts); !errors.Is(err, errGoOptions) {
+			t.Errorf("%+v: want %v, but got %v", opts, errGoOptions, err)
+		}
+	}
+	files := map[string]io.Reader{"Main.vm": strings.NewReader("push constant 1\n")}
+	if err := Translate(files, io.Discard, Options{Go: true, ExactCompare: true, Inline: 8}); err != nil {
+		t.Errorf("want Go programs with ExactCompare and Inline, but got %v", err)
+	}
+}
+
+func TestCodeWriterErr(t *testing.T) {
+	w := NewCodeWriter(io.Discard)
+	w.SetFilename("Main.vm")
+	w.WriteCommands([]Command{{Type: C_PUSH, Arg1: "temp", Arg2: 8, Line: 7}})
+	var e *Error
+	if !errors.As(w.Err(), &e) || e.Error() != "Main.vm:7: index 8 is out of temp segment (0-7)" {
+		t.Errorf("got %v", w.Err())
+	}
+}
@@ -0,0 +1,195 @@
+package vm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// commandArgs is the number of arguments of each command type.
+var commandArgs = map[CommandType]int{
+	C_ARITHMETIC: 0,
+	C_PUSH:       2,
+	C_POP:        2,
+	C_LABEL:      1,
+	C_GOTO:       1,
+	C_IF:         1,
+	C_FUNCTION:   2,
+	C_RETURN:     0,
+	C_CALL:       2,
+}
+
+// segmentSizes is the number of valid indices of each segment.
+// Segments of unlimited size map to 0.
+var segmentSizes = map[string]int{
+	"argument": 0,
+	"local":    0,
+	"static":   0,
+	"constant": 32768,
+	"this":     0,
+	"that":     0,
+	"pointer":  2,
+	"temp":     8,
+}
+
+type location struct {
+	file string
+	line int
+}
+
+type validator struct {
+	errors    ErrorList
+	functions map[string]location
+	calls     map[string][]location
+	fileOrder map[string]int
+	extended  bool
+}
+
+// Validate checks VM files named by their base names before translation.
+// It reports unknown commands, wrong arguments, undefined labels and
+// functions and, if requireSysInit is true, a missing Sys.init as an
+// ErrorList sorted by file and line. If extended is true, the extended
+// commands are allowed.
+func Validate(files map[string]io.Reader, requireSysInit, extended bool) error {
+	srcs, err := readSources(files)
+	if err != nil {
+		return err
+	}
+	if errs := validate(srcs, requireSysInit, extended); len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func validate(srcs []source, requireSysInit, extended bool) ErrorList {
+	v := validator{
+		functions: make(map[string]location),
+		calls:     make(map[string][]location),
+		fileOrder: make(map[string]int),
+		extended:  extended,
+	}
+	for i, src := range srcs {
+		v.fileOrder[src.name] = i
+		v.validateFile(src)
+	}
+
+	for funcName, locs := range v.calls {
+		if _, ok := v.functions[funcName]; ok {
+			continue
+		}
+		for _, loc := range locs {
+			v.report(loc, "call to undefined function %s", funcName)
+		}
+	}
+	if _, ok := v.functions[entryFunction]; requireSysInit && !ok {
+		v.errors = append(v.errors, &Error{Message: "function Sys.init called by bootstrap code is not defined"})
+	}
+
+	sort.SliceStable(v.errors, func(i, j int) bool {
+		a, b := v.errors[i], v.errors[j]
+		if (a.File == "") != (b.File == "") {
+			return b.File == ""
+		}
+		if a.File != b.File {
+			return v.fileOrder[a.File] < v.fileOrder[b.File]
+		}
+		return a.Line < b.Line
+	})
+	return v.errors
+}
+
+func (v *validator) report(loc location, format string, args ...any) {
+	v.errors = append(v.errors, &Error{loc.file, loc.line, fmt.Sprintf(format, args...)})
+}
+
+func (v *validator) validateFile(src source) {
+	file := src.name
+	// Labels are scoped by function.
+	function := ""
+	labels := make(map[string]location)
+	jumps := make(map[string][]location)
+	checkJumps := func() {
+		for label, locs := range jumps {
+			if _, ok := labels[label]; ok {
+				continue
+			}
+			for _, loc := range locs {
+				if function == "" {
+					v.report(loc, "undefined label %s", label)
+				} else {
+					v.report(loc, "undefined label %s in function %s", label, function)
+				}
+			}
+		}
+	}
+
+	p := NewParser(bytes.NewReader(src.data))
+	p.SetExtended(v.extended)
+	for p.HasMoreCommands() {
+		p.Advance()
+		loc := location{file, p.Line()}
+		fields := p.Fields()
+
+		typ, ok := lookupCommand(fields[0], v.extended)
+		if !ok {
+			v.report(loc, "unknown command %s", fields[0])
+			continue
+		}
+		if want := commandArgs[typ] + 1; len(fields) != want {
+			v.report(loc, "%s takes %d arguments, but got %d", fields[0], want-1, len(fields)-1)
+			continue
+		}
+
+		var arg2 int
+		if commandArgs[typ] == 2 {
+			var err error
+			arg2, err = strconv.Atoi(fields[2])
+			if err != nil || arg2 < 0 {
+				v.report(loc, "second argument of %s must be a non-negative integer, but got %s", fields[0], fields[2])
+				continue
+			}
+		}
+
+		switch typ {
+		case C_PUSH, C_POP:
+			v.validateSegment(loc, typ, fields[1], arg2)
+		case C_LABEL:
+			if prev, ok := labels[fields[1]]; ok {
+				v.report(loc, "label %s is already defined at line %d", fields[1], prev.line)
+			}
+			labels[fields[1]] = loc
+		case C_GOTO, C_IF:
+			jumps[fields[1]] = append(jumps[fields[1]], loc)
+		case C_FUNCTION:
+			checkJumps()
+			function = fields[1]
+			labels = make(map[string]location)
+			jumps = make(map[string][]location)
+			if prev, ok := v.functions[function]; ok {
+				v.report(loc, "function %s is already defined at %s:%d", function, prev.file, prev.line)
+			} else {
+				v.functions[function] = loc
+			}
+		case C_CALL:
+			v.calls[fields[1]] = append(v.calls[fields[1]], loc)
+		}
+	}
+	checkJumps()
+	if err := p.Err(); err != nil {
+		v.report(location{file, 0}, "%v", err)
+	}
+}
+
+func (v *validator) validateSegment(loc location, typ CommandType, segment string, index int) {
+	size, ok := segmentSizes[segment]
+	switch {
+	case !ok:
+		v.report(loc, "unknown segment %s", segment)
+	case typ == C_POP && segment == "constant":
+		v.report(loc, "cannot pop to constant segment")
+	case size > 0 && index >= size:
+		v.report(loc, "index %d is out of %s segment (0-%d)", index, segment, size-1)
+	}
+}
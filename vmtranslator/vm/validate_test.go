@@ -0,0 +1,81 @@
+package vm
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidate(t *testing.T) {
+	paths := writeVMFiles(t, map[string]string{
+		"Main.vm": `function Main.main 1
+	push constant 32768
+	push local
+	pop constant 0
+	push temp 8
+	push pointer 2
+	push heap 0
+	pop local x
+	mul
+	label LOOP
+	goto LOOP
+	if-goto END
+	label LOOP
+	call Main.missing 0
+	return
+function Main.main 0
+	goto LOOP
+	return
+`,
+		"Util.vm": `// no function yet
+label TOP
+goto TOP
+function Util.f 0
+	call Main.main 0
+	call Main.missing 1
+	return
+`,
+	})
+
+	wants := []string{
+		"Main.vm:2: index 32768 is out of constant segment (0-32767)",
+		"Main.vm:3: push takes 2 arguments, but got 1",
+		"Main.vm:4: cannot pop to constant segment",
+		"Main.vm:5: index 8 is out of temp segment (0-7)",
+		"Main.vm:6: index 2 is out of pointer segment (0-1)",
+		"Main.vm:7: unknown segment heap",
+		"Main.vm:8: second argument of pop must be a non-negative integer, but got x",
+		"Main.vm:9: unknown command mul",
+		"Main.vm:12: undefined label END in function Main.main",
+		"Main.vm:13: label LOOP is already defined at line 10",
+		"Main.vm:14: call to undefined function Main.missing",
+		"Main.vm:16: function Main.main is already defined at Main.vm:1",
+		"Main.vm:17: undefined label LOOP in function Main.main",
+		"Util.vm:6: call to undefined function Main.missing",
+		"function Sys.init called by bootstrap code is not defined",
+	}
+
+	var got ErrorList
+	if err := Validate(readVMFiles(t, paths), true, false); !errors.As(err, &got) {
+		t.Fatalf("want an ErrorList, but got %v", err)
+	}
+	if len(got) != len(wants) {
+		t.Fatalf("want %d errors, but got %d: %v", len(wants), len(got), got)
+	}
+	for i, want := range wants {
+		if got[i].Error() != want {
+			t.Errorf("want %q, but got %q", want, got[i])
+		}
+	}
+}
+
+func TestValidateProjects(t *testing.T) {
+	for _, dir := range []string{
+		"../../projects/08/FunctionCalls/FibonacciElement",
+		"../../projects/08/FunctionCalls/StaticsTest",
+		"../../projects/09/hilow",
+	} {
+		if err := Validate(readVMFiles(t, vmFilesIn(t, dir)), true, false); err != nil {
+			t.Errorf("%s: want no errors, but got %v", dir, err)
+		}
+	}
+}
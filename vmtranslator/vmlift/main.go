@@ -0,0 +1,68 @@
+// Vmlift lifts Hack assembly written by vmtranslator without options back
+// to VM commands.
+//
+// Without a file, it lifts stdin. It writes the VM file to stdout unless
+// -o is given, with the regions it cannot lift as comments, and it reports
+// the regions and fails if there are any.
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"vmtranslator/vm"
+)
+
+func main() {
+	output := flag.String("o", "", "output `FILE.vm` instead of stdout")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [FILE.asm]\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	name := "<stdin>"
+	var in io.Reader = os.Stdin
+	if flag.NArg() == 1 {
+		name = flag.Arg(0)
+		f, err := os.Open(name)
+		if err != nil {
+			Die("%v", err)
+		}
+		defer f.Close()
+		in = f
+	}
+	prog, err := vm.Lift(in)
+	if err != nil {
+		Die("%s: %v", name, err)
+	}
+
+	var buf bytes.Buffer
+	if err := prog.WriteVM(&buf); err != nil {
+		Die("%v", err)
+	}
+	if *output == "" {
+		os.Stdout.Write(buf.Bytes())
+	} else if err := os.WriteFile(*output, buf.Bytes(), 0o644); err != nil {
+		Die("%v", err)
+	}
+
+	for _, r := range prog.Unlifted {
+		fmt.Fprintf(os.Stderr, "%s:%d-%d: cannot lift %d instructions\n", name, r.Start, r.End, len(r.Instructions))
+	}
+	if len(prog.Unlifted) > 0 {
+		os.Exit(1)
+	}
+}
+
+func Die(format string, args ...any) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}